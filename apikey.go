@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/auth"
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+const apiKeyUsage = "usage: apikey create <name> <scope,...>|list|revoke <key id>"
+
+// runAPIKey Run apikey subcommand to manage API keys
+func runAPIKey(store model.APIKeyStore, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) != 3 {
+			return errors.New(apiKeyUsage)
+		}
+		scopes := strings.Split(args[2], ",")
+		for _, scope := range scopes {
+			if !auth.ValidScope(scope) {
+				return fmt.Errorf("unknown scope %v", scope)
+			}
+		}
+
+		keyId, secret, err := auth.GenerateKey()
+		if err != nil {
+			return err
+		}
+		key := model.APIKey{KeyId: keyId, Secret: secret, Name: args[1], Scopes: strings.Join(scopes, ",")}
+		if err = store.CreateAPIKey(&key); err != nil {
+			return err
+		}
+		fmt.Printf("Key id: %v\nSecret: %v\nThe secret is not shown again.\n", keyId, secret)
+		return nil
+	case "list":
+		keys, err := store.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			state := "active"
+			if key.RevokedAt != nil {
+				state = "revoked " + key.RevokedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%v  %-24v %-20v %v\n", key.KeyId, key.Name, key.Scopes, state)
+		}
+		return nil
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apiKeyUsage)
+		}
+		if err := store.RevokeAPIKey(args[1], time.Now()); err != nil {
+			if errors.Is(err, model.ErrNotFound) {
+				return fmt.Errorf("no active key %v", args[1])
+			}
+			return err
+		}
+		fmt.Printf("Revoked %v\n", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown apikey command %v", args[0])
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of API keys, admin keys are allowed every scope
+const (
+	ScopeRead   = "read"
+	ScopeIngest = "ingest"
+	ScopeAdmin  = "admin"
+)
+
+// Headers of signed requests
+const (
+	HeaderKey       = "X-Api-Key"
+	HeaderTimestamp = "X-Timestamp" // Unix seconds
+	HeaderSignature = "X-Signature"
+)
+
+// ValidScope Check whether scope is known
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeIngest || scope == ScopeAdmin
+}
+
+// HasScope Check whether comma separated scopes allow scope
+func HasScope(scopes, scope string) bool {
+	for _, s := range strings.Split(scopes, ",") {
+		s = strings.TrimSpace(s)
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateKey Generate id and secret of a new API key
+func GenerateKey() (string, string, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(id), hex.EncodeToString(secret), nil
+}
+
+// CanonicalQuery Sort parameters of query by key and value so both sides sign the same string
+func CanonicalQuery(query string) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return query
+	}
+	return values.Encode()
+}
+
+// StringToSign Build the string signed by a request: method, escaped path, canonical query,
+// timestamp and hex SHA-256 of body separated by newlines
+func StringToSign(method, path, query, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		CanonicalQuery(query),
+		timestamp,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// Sign Get hex HMAC-SHA256 of the string to sign with secret
+func Sign(secret, method, path, query, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(method, path, query, timestamp, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify Check signature of a request in constant time
+func Verify(secret, signature, method, path, query, timestamp string, body []byte) bool {
+	expected := Sign(secret, method, path, query, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// SignRequest Add key, timestamp and signature headers to req whose body is body
+func SignRequest(req *http.Request, keyId, secret string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderKey, keyId)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(secret, req.Method, req.URL.EscapedPath(), req.URL.RawQuery, timestamp, body))
+}
@@ -2,24 +2,72 @@ package config
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
 )
 
 type Config struct {
-	Host      string               `json:"host"`
-	Port      uint                 `json:"port"`
-	Username  string               `json:"username"`
-	Password  string               `json:"password"`
-	Database  string               `json:"database"`
-	DBHost    string               `json:"db_host"`
-	DBPort    uint                 `json:"db_port"`
-	RedisHost string               `json:"redis_host"`
-	RedisPort string               `json:"redis_port"`
-	SessionId string               `json:"session_id"`
-	AsmToken  string               `json:"asm_token"`
-	Timeout   int                  `json:"timeout"`
-	Servers   []ServerDistribution `json:"servers"`
+	Host       string               `json:"host"`
+	Port       uint                 `json:"port"`
+	Username   string               `json:"username"`
+	Password   string               `json:"password"`
+	Database   string               `json:"database"`
+	DBHost     string               `json:"db_host"`
+	DBPort     uint                 `json:"db_port"`
+	Storage    string               `json:"storage"` // mysql or sqlite
+	SQLitePath string               `json:"sqlite_path"`
+	Cache      string               `json:"cache"`      // redis or memory
+	CacheSize  int                  `json:"cache_size"` // Keys kept by memory cache
+	RedisHost  string               `json:"redis_host"`
+	RedisPort  string               `json:"redis_port"`
+	SessionId  string               `json:"session_id"`
+	AsmToken   string               `json:"asm_token"`
+	Timeout    int                  `json:"timeout"`    // Seconds to wait for a crawler request
+	RateLimit  float64              `json:"rate_limit"` // Crawler requests per second to a host
+	RateBurst  int                  `json:"rate_burst"`
+	Retries    int                  `json:"retries"` // Retries of a failed crawler request
+	Servers    []ServerDistribution `json:"servers"`
+	Forums     []Forum              `json:"forums"`
+	Schedules  map[string]string    `json:"schedules"`   // Cron expressions of scheduled jobs, empty to disable
+	NotifyKey  string               `json:"notify_key"`  // Key of WeCom webhook notified when a roster crawl finishes
+	ProbeTieba bool                 `json:"probe_tieba"` // Check that tieba is reachable in readiness checks
+
+	DisableLegacyToken bool   `json:"disable_legacy_token"` // Only accept requests signed by API keys
+	SignatureWindow    int    `json:"signature_window"`     // Seconds a signed request is valid for
+	DistKeyId          string `json:"dist_key_id"`          // API key signing requests to distribution servers
+	DistSecret         string `json:"dist_secret"`
+
+	DistTimeout int `json:"dist_timeout"` // Seconds to wait for a distribution server
+	DistRetries int `json:"dist_retries"` // Retries of a distribution server before trying the next one
+
+	ProxyHeader string               `json:"proxy_header"` // Header with the client IP set by a reverse proxy, e.g. X-Real-IP
+	RateLimits  map[string]RateLimit `json:"rate_limits"`  // Limits of clients by "METHOD /path" or "/path" for all methods, "default" applies to other routes
+}
+
+// RateLimit Requests a client is allowed to send to a route in each window
+type RateLimit struct {
+	Requests int `json:"requests"`
+	Window   int `json:"window"` // Seconds
+}
+
+// GetRateLimit Get limit of method on route, a limit of the method is preferred to one of the path.
+// ok is false when the route is not limited.
+func (cf Config) GetRateLimit(method, route string) (RateLimit, bool) {
+	limit, ok := cf.RateLimits[method+" "+route]
+	if !ok {
+		limit, ok = cf.RateLimits[route]
+	}
+	if !ok {
+		limit, ok = cf.RateLimits["default"]
+	}
+	if !ok || limit.Requests <= 0 || limit.Window <= 0 {
+		return RateLimit{}, false
+	}
+	return limit, true
 }
 
 type ServerDistribution struct {
@@ -27,18 +75,140 @@ type ServerDistribution struct {
 	Server string `json:"server"`
 }
 
-func GetConfig() Config {
-	jsonFile, err := os.Open("etc/config.json")
-	if err != nil {
-		log.Fatal(err)
+// Forum A tieba tracked by the server
+type Forum struct {
+	Key  string `json:"key"`  // Identifier used in requests, redis keys and database rows
+	Name string `json:"name"` // Name of the tieba, i.e. the kw parameter
+}
+
+// DefaultForum Forum used when no forums are configured
+var DefaultForum = Forum{Key: "genshin", Name: C.TIEBA}
+
+// GetForum Get configured forum by key, the first forum is returned for an empty key
+func (cf Config) GetForum(key string) (Forum, bool) {
+	forums := cf.Forums
+	if len(forums) == 0 {
+		forums = []Forum{DefaultForum}
+	}
+	if key == "" {
+		return forums[0], true
+	}
+	for _, forum := range forums {
+		if forum.Key == key {
+			return forum, true
+		}
 	}
-	defer jsonFile.Close()
+	return Forum{}, false
+}
 
+// GetForums Get all configured forums
+func (cf Config) GetForums() []Forum {
+	if len(cf.Forums) == 0 {
+		return []Forum{DefaultForum}
+	}
+	return cf.Forums
+}
+
+// DistributeServers Get servers to calculate distribution of level, servers of level 0 accept every level
+func (cf Config) DistributeServers(level uint) []string {
+	servers := make([]string, 0)
+	for _, sd := range cf.Servers {
+		if sd.Level == level {
+			servers = append(servers, sd.Server)
+		}
+	}
+	for _, sd := range cf.Servers {
+		if sd.Level == 0 && level != 0 {
+			servers = append(servers, sd.Server)
+		}
+	}
+	return servers
+}
+
+// DefaultPath Path of config file used when no path is given
+const DefaultPath = "etc/config.json"
+
+// Load Load config from JSON file in path and overrides in TIEBARANK_* environment variables,
+// an empty path loads config from environment variables only
+func Load(path string) (Config, error) {
 	var result Config
-	err = json.NewDecoder(jsonFile).Decode(&result)
-	if err != nil {
-		log.Fatal(err)
+	if path != "" {
+		jsonFile, err := os.Open(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("open config: %w", err)
+		}
+		defer jsonFile.Close()
+
+		if err = json.NewDecoder(jsonFile).Decode(&result); err != nil {
+			return Config{}, fmt.Errorf("parse config %v: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(&result, os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+	if err := result.Validate(); err != nil {
+		return Config{}, err
+	}
+	return result, nil
+}
+
+// Validate Check that settings required by the selected storage and cache are present
+func (cf Config) Validate() error {
+	var problems []string
+	missing := func(name, env string) {
+		problems = append(problems, fmt.Sprintf("%v is required (%v)", name, env))
+	}
+
+	if cf.Port == 0 {
+		missing("port", envName("port"))
+	}
+
+	switch cf.Storage {
+	case "", "mysql":
+		if cf.DBHost == "" {
+			missing("db_host", envName("db_host"))
+		}
+		if cf.DBPort == 0 {
+			missing("db_port", envName("db_port"))
+		}
+		if cf.Username == "" {
+			missing("username", envName("username"))
+		}
+		if cf.Database == "" {
+			missing("database", envName("database"))
+		}
+	case "sqlite":
+	default:
+		problems = append(problems, fmt.Sprintf("storage must be mysql or sqlite, got %q", cf.Storage))
 	}
 
-	return result
+	switch cf.Cache {
+	case "", "redis":
+		if cf.RedisHost == "" {
+			missing("redis_host", envName("redis_host"))
+		}
+		if cf.RedisPort == "" {
+			missing("redis_port", envName("redis_port"))
+		}
+	case "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("cache must be redis or memory, got %q", cf.Cache))
+	}
+
+	forums := make(map[string]bool)
+	for i, forum := range cf.Forums {
+		if forum.Key == "" || forum.Name == "" {
+			problems = append(problems, fmt.Sprintf("forums[%d] needs both key and name", i))
+		}
+		if forums[forum.Key] {
+			problems = append(problems, fmt.Sprintf("forums[%d] has duplicate key %q", i, forum.Key))
+		}
+		forums[forum.Key] = true
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+	return nil
 }
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix Prefix of environment variables overriding config, e.g. TIEBARANK_DB_HOST overrides db_host
+const EnvPrefix = "TIEBARANK_"
+
+func envName(field string) string {
+	return EnvPrefix + strings.ToUpper(field)
+}
+
+// applyEnv Override fields of cf with environment variables named after their json keys.
+// Lists and maps like servers and forums are given as JSON.
+func applyEnv(cf *Config, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(cf).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := envName(key)
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		var err error
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			var n int64
+			n, err = strconv.ParseInt(value, 10, 0)
+			field.SetInt(n)
+		case reflect.Uint:
+			var n uint64
+			n, err = strconv.ParseUint(value, 10, 0)
+			field.SetUint(n)
+		case reflect.Float64:
+			var n float64
+			n, err = strconv.ParseFloat(value, 64)
+			field.SetFloat(n)
+		case reflect.Bool:
+			var b bool
+			b, err = strconv.ParseBool(value)
+			field.SetBool(b)
+		default:
+			err = json.Unmarshal([]byte(value), field.Addr().Interface())
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %v: %w", name, err)
+		}
+	}
+	return nil
+}
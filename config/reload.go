@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Reloadable Settings applied at runtime when config is reloaded, changes of other settings need a restart
+var Reloadable = map[string]bool{
+	"servers":      true,
+	"timeout":      true,
+	"rate_limit":   true,
+	"rate_burst":   true,
+	"retries":      true,
+	"dist_timeout": true,
+	"dist_retries": true,
+	"session_id":   true,
+	"asm_token":    true,
+	"notify_key":   true,
+	"probe_tieba":  true,
+
+	"disable_legacy_token": true,
+	"signature_window":     true,
+	"dist_key_id":          true,
+	"dist_secret":          true,
+	"rate_limits":          true,
+}
+
+// Live Config shared by packages, reloadable settings are swapped when config is reloaded
+type Live struct {
+	value atomic.Value
+	mu    sync.Mutex // Serializes reloads
+}
+
+// NewLive Create live config starting with cf
+func NewLive(cf Config) *Live {
+	l := &Live{}
+	l.value.Store(cf)
+	return l
+}
+
+// Get Get current config
+func (l *Live) Get() Config {
+	return l.value.Load().(Config)
+}
+
+// Reload Apply reloadable settings of next, keys of other settings that changed are returned
+func (l *Live) Reload(next Config) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	merged := l.Get()
+	mv := reflect.ValueOf(&merged).Elem()
+	nv := reflect.ValueOf(next)
+	t := mv.Type()
+
+	restart := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if reflect.DeepEqual(mv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if Reloadable[key] {
+			mv.Field(i).Set(nv.Field(i))
+			log.Printf("Config %v reloaded", key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+
+	l.value.Store(merged)
+	return restart
+}
+
+// reloadFile Load config in path again and apply it, invalid config is ignored
+func (l *Live) reloadFile(path string) {
+	next, err := Load(path)
+	if err != nil {
+		log.Printf("Config not reloaded: %v", err)
+		return
+	}
+	if restart := l.Reload(next); len(restart) > 0 {
+		log.Printf("Config %v changed, restart the server to apply", strings.Join(restart, ", "))
+	}
+}
+
+// Watch Reload config in path when the file changes or SIGHUP is received, until ctx is done.
+// The file is checked for changes every interval.
+func (l *Live) Watch(ctx context.Context, path string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	modTime := fileModTime(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("SIGHUP received, reloading config")
+			modTime = fileModTime(path)
+			l.reloadFile(path)
+		case <-ticker.C:
+			if path == "" {
+				continue
+			}
+			if t := fileModTime(path); !t.Equal(modTime) {
+				modTime = t
+				log.Printf("Config file %v changed, reloading config", path)
+				l.reloadFile(path)
+			}
+		}
+	}
+}
+
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,245 @@
+package crawler
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/metrics"
+)
+
+// Default settings of Client used when they are not configured
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultRateLimit = 5.0
+	DefaultRetries   = 3
+	DefaultBackoff   = 500 * time.Millisecond
+)
+
+var defaultHeader = map[string]string{
+	"User-Agent":      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.93 Safari/537.36",
+	"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8",
+	"Accept-Language": "zh-CN,zh;q=0.9,en;q=0.8",
+	"Cache-Control":   "no-cache",
+}
+
+// Client HTTP client with per host rate limiting and retries, shared by all outgoing requests
+type Client struct {
+	http      *http.Client
+	rateLimit float64 // Requests per second to a host
+	burst     float64
+	retries   int
+	backoff   time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket Token bucket of a host
+type bucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+var clients = struct {
+	sync.Mutex
+	client   *Client
+	settings [4]float64 // Settings the client was created with
+}{}
+
+// NewClient Create client with timeout, rate limit and retries in cf, zero values are replaced by defaults
+func NewClient(cf config.Config) *Client {
+	timeout := time.Duration(cf.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	rateLimit := cf.RateLimit
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+	burst := float64(cf.RateBurst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(rateLimit))
+	}
+	retries := cf.Retries
+	if retries <= 0 {
+		retries = DefaultRetries
+	}
+
+	return &Client{
+		http:      &http.Client{Timeout: timeout},
+		rateLimit: rateLimit,
+		burst:     burst,
+		retries:   retries,
+		backoff:   DefaultBackoff,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// DefaultClient Get the client shared by the crawler, it is created again when its settings are reloaded
+func DefaultClient() *Client {
+	cf := conf.Get()
+	settings := [4]float64{float64(cf.Timeout), cf.RateLimit, float64(cf.RateBurst), float64(cf.Retries)}
+
+	clients.Lock()
+	defer clients.Unlock()
+	if clients.client == nil || clients.settings != settings {
+		clients.client = NewClient(cf)
+		clients.settings = settings
+	}
+	return clients.client
+}
+
+// wait Block until a request to host is allowed
+func (c *Client) wait(ctx context.Context, host string) error {
+	c.mu.Lock()
+	b, ok := c.buckets[host]
+	if !ok {
+		b = &bucket{tokens: c.burst, last: time.Now()}
+		c.buckets[host] = b
+	}
+	c.mu.Unlock()
+
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = math.Min(c.burst, b.tokens+now.Sub(b.last).Seconds()*c.rateLimit)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / c.rateLimit * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Sleep Wait for d, ctx.Err() is returned when ctx is done first
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryAfter Get delay requested by Retry-After header
+func retryAfter(res *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Target Get name of the site requested, used to label metrics
+func Target(u *url.URL) string {
+	switch {
+	case strings.HasSuffix(u.Host, "chandashi.com"):
+		return "chandashi"
+	case strings.HasSuffix(u.Host, "bing.com"):
+		return "bing"
+	case strings.HasPrefix(u.Path, "/f/like/furank"):
+		return "furank"
+	case strings.HasPrefix(u.Path, "/home/"):
+		return "user"
+	case u.Path == "/f":
+		return "forum"
+	default:
+		return "other"
+	}
+}
+
+// Do Send request, retrying with exponential backoff on connection errors, 429 and 5xx.
+// The response of the last attempt is returned when all retries fail.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := c.do(req)
+	metrics.ObserveCrawl(Target(req.URL), start, err != nil || res.StatusCode >= 400)
+	return res, err
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for k, v := range defaultHeader {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+
+		r := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+
+		res, err := c.http.Do(r)
+		var delay time.Duration
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		} else if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+			delay = retryAfter(res)
+		} else {
+			return res, nil
+		}
+
+		if attempt >= c.retries {
+			return res, err
+		}
+		if res != nil {
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			log.Printf("Retrying %v after status %d", req.URL, res.StatusCode)
+		} else {
+			log.Printf("Retrying %v after err: %v", req.URL, err)
+		}
+
+		if delay == 0 {
+			delay = c.backoff << uint(attempt)
+		}
+		if err := Sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Get Send GET request to url
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Get Send GET request with the default client
+func Get(ctx context.Context, url string) (*http.Response, error) {
+	return DefaultClient().Get(ctx, url)
+}
@@ -8,21 +8,15 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/http/cookiejar"
-	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/metrics"
 	"github.com/DRJ31/tiebarankgo/model"
 	C "github.com/DRJ31/tiebarankgo/secrets/constants"
 	"github.com/PuerkitoBio/goquery"
-	"github.com/go-redis/redis/v8"
-	"golang.org/x/text/encoding/simplifiedchinese"
-	"golang.org/x/text/transform"
-	"gorm.io/gorm"
 )
 
 type MyError struct {
@@ -35,130 +29,71 @@ func (e *MyError) Error() string {
 
 var ErrUserNotFound = errors.New("user not found")
 
-// GetUsers Get multiple users in a page
-func GetUsers(tieba string, page uint) ([]model.TiebaUser, error) {
+var (
+	conf  *config.Live
+	cache model.Cache
+)
+
+// Setup Set config of the crawler and cache of crawled totals
+func Setup(cf *config.Live, c model.Cache) {
+	conf = cf
+	cache = c
+}
+
+// GetFurank Get and parse a furank page of a tieba
+func GetFurank(ctx context.Context, tieba string, page uint) ([]model.TiebaUser, uint, error) {
 	site := fmt.Sprintf("http://tieba.baidu.com/f/like/furank?kw=%s&pn=%v", tieba, page)
 
 	// Get content of webpage
-	res, err := http.Get(site)
+	res, err := Get(ctx, site)
 	if err != nil {
 		log.Printf("Crawl err: %v", err)
-		return nil, err
+		return nil, 0, err
 	}
-
-	// Ensure correct display of Chinese
-	utf8Reader := transform.NewReader(res.Body, simplifiedchinese.GBK.NewDecoder())
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		log.Printf("Status code err: %d %s", res.StatusCode, res.Status)
-		return nil, &MyError{
+		return nil, 0, &MyError{
 			fmt.Sprintf("%d %s", res.StatusCode, res.Status),
 		}
 	}
 
-	// Create document from webpage
-	doc, err := goquery.NewDocumentFromReader(utf8Reader)
-	if err != nil {
-		log.Printf("New document err: %v", err)
-		return nil, err
-	}
-
-	tiebaUsers := make([]model.TiebaUser, 0)
-
-	db, err := model.Init()
-	defer model.Close(db)
+	return ParseFurank(res.Body)
+}
 
-	// Get total users
-	total, err := strconv.ParseUint(doc.Find(".drl_info_txt_gray").Text(), C.BASE, C.BITSIZE)
+// GetUsers Get multiple users in a page, nicknames of known users are taken from store
+func GetUsers(ctx context.Context, forum config.Forum, page uint, store model.UserStore) ([]model.TiebaUser, error) {
+	tiebaUsers, total, err := GetFurank(ctx, forum.Name, page)
 	if err != nil {
-		log.Printf("Total parse err: %v", err)
+		return nil, err
 	}
-	var ctx = context.Background()
-	rdb := model.InitRedis()
-	defer rdb.Close()
-	rdb.Set(ctx, "tieba_genshin_member_total", total, 0)
-
-	doc.Find(".drl_list_item").Each(func(i int, s *goquery.Selection) {
-		// Check if the user is VIP
-		vip := s.Find(".drl_item_card").HasClass("drl_item_vip")
-
-		// Get Rank of user
-		rank, e := strconv.ParseUint(s.Find(".drl_item_index").Text(), C.BASE, C.BITSIZE)
-		if e != nil {
-			log.Printf("Rank parse err: %v", e)
-			err = e
-			return
-		}
 
-		// Get experience value of user
-		exp, e := strconv.ParseUint(s.Find(".drl_item_exp").Text(), C.BASE, C.BITSIZE)
-		if e != nil {
-			log.Printf("Exp parse err: %v", e)
-			err = e
-			return
-		}
-
-		// Get link of user
-		link, ok := s.Find(".drl_item_card").Find("a").Attr("href")
-		if !ok {
-			log.Println("Failed to find link")
-			err = &MyError{"Failed to find link"}
-			return
-		}
-
-		// Get level string of user
-		level, ok := s.Find(".drl_item_title").Find("div").Attr("class")
-		if !ok {
-			log.Println("Failed to find level")
-			err = &MyError{"Failed to find level"}
-			return
-		}
-		level = strings.Split(level, "lv")[1]
-		lv, e := strconv.ParseUint(level, C.BASE, C.BITSIZE)
-		if e != nil {
-			log.Printf("Level parse err: %v", e)
-			err = e
-			return
+	// Save total users
+	if total > 0 {
+		if err = cache.Set(ctx, model.MemberTotalKey(forum.Key), total, 0); err != nil {
+			log.Println(err)
 		}
+	}
 
-		name := s.Find(".drl_item_card").Text()
-		var user model.User
-		var nickname string
-		result := db.First(&user, "name = ?", name)
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			userAvatar, e := GetUser(link)
-			if e != nil {
-				if !errors.Is(e, ErrUserNotFound) {
-					err = e
-					return
-				}
+	for i := range tiebaUsers {
+		user, err := store.GetUser(forum.Key, tiebaUsers[i].Name)
+		if errors.Is(err, model.ErrNotFound) {
+			userAvatar, e := GetUser(ctx, tiebaUsers[i].Link)
+			if e != nil && !errors.Is(e, ErrUserNotFound) {
+				return nil, e
 			}
-			nickname = userAvatar.Nickname
+			tiebaUsers[i].Nickname = userAvatar.Nickname
 		} else {
-			nickname = user.Nickname
+			tiebaUsers[i].Nickname = user.Nickname
 		}
-
-		// Construct final result
-		tiebaUsers = append(tiebaUsers, model.TiebaUser{
-			Rank:     uint(rank),
-			Member:   vip,
-			Name:     name,
-			Exp:      uint(exp),
-			Link:     link,
-			Level:    uint(lv),
-			Nickname: nickname,
-		})
-	})
-
-	if err != nil {
-		return nil, err
 	}
+
 	return tiebaUsers, nil
 }
 
 // GetUser Get single user information
-func GetUser(url string) (model.UserAvatar, error) {
-	res, err := http.Get("http://tieba.baidu.com" + url)
+func GetUser(ctx context.Context, url string) (model.UserAvatar, error) {
+	res, err := Get(ctx, "http://tieba.baidu.com"+url)
 	if err != nil {
 		log.Printf("Crawl err: %v", err)
 		return model.UserAvatar{}, err
@@ -191,84 +126,68 @@ func GetUser(url string) (model.UserAvatar, error) {
 	return model.UserAvatar{Avatar: avatar, Nickname: nickname}, nil
 }
 
-// GetDistribution Get multiple users in a page
-func GetDistribution(tieba string, page int, level uint, ch chan uint, wg *sync.WaitGroup) {
-	defer wg.Done()
-	site := fmt.Sprintf("http://tieba.baidu.com/f/like/furank?kw=%s&pn=%v", tieba, page)
+// GetDistribution Get the rank before the first user whose level is lower than level in a page
+func GetDistribution(ctx context.Context, tieba string, page uint, level uint) (model.PageLevel, error) {
+	// Rows that failed to parse are skipped, unless none is left which would look like the end of ranking
+	users, _, err := GetFurank(ctx, tieba, page)
+	var rowErr *RowError
+	if err != nil && (!errors.As(err, &rowErr) || len(users) == 0) {
+		return model.PageLevel{}, err
+	}
 
-	// Get content of webpage
-	res, err := http.Get(site)
-	if err != nil {
-		log.Printf("Crawl err: %v", err)
-		return
+	var result model.PageLevel
+	for _, user := range users {
+		if user.Level < level && !result.Found {
+			result.Found = true
+			result.Rank = user.Rank - 1
+		}
+		result.Last = user.Rank
 	}
 
-	// Ensure correct display of Chinese
-	utf8Reader := transform.NewReader(res.Body, simplifiedchinese.GBK.NewDecoder())
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		log.Printf("Status code err: %d %s", res.StatusCode, res.Status)
-		return
+	return result, nil
+}
+
+// MemberTotal Get total members of a forum, crawled from the first furank page when it is not cached
+func MemberTotal(ctx context.Context, forum config.Forum) (uint, error) {
+	var members uint
+	err := cache.Get(ctx, model.MemberTotalKey(forum.Key), &members)
+	if err == nil {
+		return members, nil
+	}
+	if !errors.Is(err, model.ErrCacheMiss) {
+		log.Println(err)
 	}
 
-	// Create document from webpage
-	doc, err := goquery.NewDocumentFromReader(utf8Reader)
+	// The total is only cached by crawls of furank pages, which may not have run since a restart
+	_, members, err = GetFurank(ctx, forum.Name, 1)
 	if err != nil {
-		log.Printf("New document err: %v", err)
-		return
+		return 0, err
 	}
-
-	doc.Find(".drl_list_item").Each(func(i int, s *goquery.Selection) {
-		// Get Rank of user
-		rank, e := strconv.ParseUint(s.Find(".drl_item_index").Text(), C.BASE, C.BITSIZE)
-		if e != nil {
-			log.Printf("Rank parse err: %v", e)
-			err = e
-			return
-		}
-
-		// Get level string of user
-		levelStr, ok := s.Find(".drl_item_title").Find("div").Attr("class")
-		if !ok {
-			log.Println("Failed to find level")
-			err = &MyError{"Failed to find level"}
-			return
-		}
-		levelStr = strings.Split(levelStr, "lv")[1]
-		lv, e := strconv.ParseUint(levelStr, C.BASE, C.BITSIZE)
-		if e != nil {
-			log.Printf("Level parse err: %v", e)
-			err = e
-			return
-		}
-
-		if uint(lv) < level {
-			ch <- uint(rank - 1)
-		}
-	})
+	if members == 0 {
+		return 0, &MyError{Message: "Failed to find total members of " + forum.Name}
+	}
+	if err = cache.Set(ctx, model.MemberTotalKey(forum.Key), members, 0); err != nil {
+		log.Println(err)
+	}
+	return members, nil
 }
 
 // GetTotal Get total number of posts and members
-func GetTotal() (uint, uint, error) {
-	rdb := model.InitRedis()
-	defer rdb.Close()
-	var ctx = context.Background()
-
-	members, err := rdb.Get(ctx, "tieba_genshin_total").Uint64()
-	if err != nil {
-		return getTotal(rdb, ctx)
+func GetTotal(ctx context.Context, forum config.Forum) (uint, uint, error) {
+	var members, posts uint
+	if err := cache.Get(ctx, model.FollowerKey(forum.Key), &members); err != nil {
+		return getTotal(ctx, forum)
 	}
-
-	posts, err := rdb.Get(ctx, "tieba_genshin_post_total").Uint64()
-	if err != nil {
-		return getTotal(rdb, ctx)
+	if err := cache.Get(ctx, model.PostTotalKey(forum.Key), &posts); err != nil {
+		return getTotal(ctx, forum)
 	}
+	metrics.SetTotals(forum.Key, members, posts)
 
-	return uint(posts), uint(members), nil
+	return posts, members, nil
 }
 
-func getTotal(rdb *redis.Client, ctx context.Context) (uint, uint, error) {
-	res, err := http.Get(fmt.Sprintf("http://tieba.baidu.com/f?ie=utf-8&kw=%s", C.TIEBA))
+func getTotal(ctx context.Context, forum config.Forum) (uint, uint, error) {
+	res, err := Get(ctx, fmt.Sprintf("http://tieba.baidu.com/f?ie=utf-8&kw=%s", forum.Name))
 	if err != nil {
 		log.Printf("Crawl err: %v", err)
 		return 0, 0, err
@@ -307,13 +226,14 @@ func getTotal(rdb *redis.Client, ctx context.Context) (uint, uint, error) {
 		return 0, 0, err
 	}
 
-	rdb.Set(ctx, "tieba_genshin_total", members, time.Minute)
-	rdb.Set(ctx, "tieba_genshin_post_total", posts, time.Minute)
+	_ = cache.Set(ctx, model.FollowerKey(forum.Key), members, time.Minute)
+	_ = cache.Set(ctx, model.PostTotalKey(forum.Key), posts, time.Minute)
+	metrics.SetTotals(forum.Key, uint(members), uint(posts))
 
 	return uint(posts), uint(members), nil
 }
 
-func GetIncomeData(start, end time.Time) (model.IncomeData, error) {
+func GetIncomeData(ctx context.Context, start, end time.Time) (model.IncomeData, error) {
 	endTime := end
 	if endTime.Unix() > time.Now().Add(-24*time.Hour).Unix() {
 		endTime = time.Now().Add(-24 * time.Hour)
@@ -325,29 +245,21 @@ func GetIncomeData(start, end time.Time) (model.IncomeData, error) {
 	location := fmt.Sprintf("https://app.chandashi.com/interf/v1/apps/incomeEstimateLine?country=cn&appId=1467190251&startDate=%v&endDate=%v", startDate, endDate)
 
 	// Construct request
-	cf := config.GetConfig()
-	jar, _ := cookiejar.New(nil)
-	cookies := make([]*http.Cookie, 0)
-	cookies = append(cookies, &http.Cookie{
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return model.IncomeData{}, err
+	}
+	req.AddCookie(&http.Cookie{
 		Name:  "cds_session_id",
-		Value: cf.SessionId,
+		Value: conf.Get().SessionId,
 	})
-	cookies = append(cookies, &http.Cookie{
+	req.AddCookie(&http.Cookie{
 		Name:  "cds_asm_token",
-		Value: cf.AsmToken,
+		Value: conf.Get().AsmToken,
 	})
-	loc, _ := url.Parse(location)
-	jar.SetCookies(loc, cookies)
-
-	client := &http.Client{Jar: jar}
-
-	req, err := http.NewRequest("GET", location, nil)
-	if err != nil {
-		return model.IncomeData{}, err
-	}
 
 	// Get content of webpage
-	res, err := client.Do(req)
+	res, err := DefaultClient().Do(req)
 	if err != nil {
 		log.Printf("Crawl err: %v", err)
 		return model.IncomeData{}, err
@@ -363,11 +275,22 @@ func GetIncomeData(start, end time.Time) (model.IncomeData, error) {
 
 	err = json.Unmarshal(body, &income)
 	if err != nil {
-		fmt.Println(startDate, endDate)
-		fmt.Printf("%s", body)
-		log.Println(err)
+		log.Printf("Income from %v to %v parse err: %v", startDate, endDate, err)
 		return model.IncomeData{}, err
 	}
 
 	return income, nil
 }
+
+// Probe Check that the front page of forum can be fetched
+func Probe(ctx context.Context, forum config.Forum) error {
+	res, err := Get(ctx, fmt.Sprintf("http://tieba.baidu.com/f?ie=utf-8&kw=%s", forum.Name))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return &MyError{fmt.Sprintf("%d %s", res.StatusCode, res.Status)}
+	}
+	return nil
+}
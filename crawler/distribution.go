@@ -0,0 +1,150 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+// Boundary Last rank of users whose level is at least Level
+type Boundary struct {
+	Level uint `json:"level"`
+	Rank  uint `json:"rank"`
+	Pages int  `json:"pages"` // Number of furank pages fetched to find the boundary
+}
+
+// pageSource Get levels of users in a furank page
+type pageSource func(page uint) (model.PageLevel, error)
+
+// boundarySearch Pages of a furank ranking fetched while searching for a level boundary
+type boundarySearch struct {
+	fetch pageSource
+	pages map[uint]model.PageLevel
+}
+
+func (b *boundarySearch) get(page uint) (model.PageLevel, error) {
+	if result, ok := b.pages[page]; ok {
+		return result, nil
+	}
+	result, err := b.fetch(page)
+	if err != nil {
+		return model.PageLevel{}, err
+	}
+	b.pages[page] = result
+	return result, nil
+}
+
+// past Check whether the boundary is in or before page, an empty page is past the end of ranking
+func (b *boundarySearch) past(page uint) (bool, error) {
+	result, err := b.get(page)
+	if err != nil {
+		return false, err
+	}
+	return result.Found || result.Last == 0, nil
+}
+
+// FindBoundary Get the last rank of users whose level is at least level.
+// Users in furank pages are sorted by exp, so starting from the page of rank the pages
+// are galloped to bracket the boundary, which is then located by binary search.
+func FindBoundary(ctx context.Context, forum config.Forum, level, rank uint) (Boundary, error) {
+	total, err := MemberTotal(ctx, forum)
+	if err != nil {
+		return Boundary{}, err
+	}
+	return findBoundary(func(page uint) (model.PageLevel, error) {
+		return GetDistribution(ctx, forum.Name, page, level)
+	}, level, rank, total)
+}
+
+// findBoundary Find boundary of level in pages of fetch, pages after those of total members are not fetched
+func findBoundary(fetch pageSource, level, rank, total uint) (Boundary, error) {
+	b := &boundarySearch{fetch: fetch, pages: make(map[uint]model.PageLevel)}
+
+	// One more page is allowed for members joining during the search
+	maxPage := (total+19)/20 + 1
+	start := (rank + 19) / 20
+	if start == 0 {
+		start = 1
+	}
+	if start > maxPage {
+		start = maxPage
+	}
+
+	// lo is a page before the boundary (0 when unknown), hi is a page in or after it
+	var lo, hi uint
+	isPast, err := b.past(start)
+	if err != nil {
+		return Boundary{}, err
+	}
+	if isPast {
+		hi = start
+		for step := uint(1); ; step *= 2 {
+			if hi <= step {
+				lo = 0
+				break
+			}
+			page := hi - step
+			isPast, err = b.past(page)
+			if err != nil {
+				return Boundary{}, err
+			}
+			if !isPast {
+				lo = page
+				break
+			}
+			hi = page
+		}
+	} else {
+		lo = start
+		for step := uint(1); ; step *= 2 {
+			if lo >= maxPage {
+				return Boundary{}, &MyError{Message: fmt.Sprintf("No boundary of level %d in %d pages", level, maxPage)}
+			}
+			page := lo + step
+			if page > maxPage {
+				page = maxPage
+			}
+			isPast, err = b.past(page)
+			if err != nil {
+				return Boundary{}, err
+			}
+			if isPast {
+				hi = page
+				break
+			}
+			lo = page
+		}
+	}
+
+	// Narrow down to adjacent pages
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		isPast, err = b.past(mid)
+		if err != nil {
+			return Boundary{}, err
+		}
+		if isPast {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	result := Boundary{Level: level, Pages: len(b.pages)}
+	last, err := b.get(hi)
+	if err != nil {
+		return Boundary{}, err
+	}
+	if last.Found {
+		result.Rank = last.Rank
+	} else if lo > 0 {
+		// Every user has reached the level, the boundary is the end of ranking
+		result.Rank = b.pages[lo].Last
+	}
+
+	log.Printf("Boundary of level %d found at rank %d with %d pages", level, result.Rank, result.Pages)
+	return result, nil
+}
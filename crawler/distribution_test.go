@@ -0,0 +1,85 @@
+package crawler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+// fakeRanking Pages of a ranking where the first above users have the level searched and the rest are below it.
+// Pages after the ranking are empty, unless endless is set and every page is full of users above the level.
+type fakeRanking struct {
+	members, above uint
+	endless        bool
+	fetched        int
+}
+
+func (r *fakeRanking) page(page uint) (model.PageLevel, error) {
+	r.fetched++
+	var result model.PageLevel
+	for rank := (page-1)*20 + 1; rank <= page*20; rank++ {
+		if rank > r.members && !r.endless {
+			break
+		}
+		if rank > r.above && !r.endless && !result.Found {
+			result.Found = true
+			result.Rank = rank - 1
+		}
+		result.Last = rank
+	}
+	return result, nil
+}
+
+func TestFindBoundary(t *testing.T) {
+	tests := []struct {
+		name    string
+		members uint
+		above   uint
+		rank    uint
+		want    uint
+	}{
+		{"boundary after start", 1000, 437, 100, 437},
+		{"boundary before start", 1000, 437, 900, 437},
+		{"boundary at page end", 1000, 440, 1, 440},
+		{"every user reaches level", 1000, 1000, 500, 1000},
+		{"no user reaches level", 1000, 0, 500, 0},
+		{"rank beyond members", 1000, 437, 5000, 437},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeRanking{members: tt.members, above: tt.above}
+			boundary, err := findBoundary(r.page, 10, tt.rank, tt.members)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if boundary.Rank != tt.want {
+				t.Errorf("got rank %d, want %d", boundary.Rank, tt.want)
+			}
+			if boundary.Pages > 20 {
+				t.Errorf("fetched %d pages", boundary.Pages)
+			}
+		})
+	}
+}
+
+func TestFindBoundaryEndless(t *testing.T) {
+	r := &fakeRanking{members: 1000, endless: true}
+	if _, err := findBoundary(r.page, 10, 100, r.members); err == nil {
+		t.Fatal("expected error for pages beyond members")
+	}
+	if r.fetched > 20 {
+		t.Errorf("fetched %d pages", r.fetched)
+	}
+}
+
+func TestFindBoundaryError(t *testing.T) {
+	errPage := errors.New("page failed")
+	fetch := func(page uint) (model.PageLevel, error) {
+		return model.PageLevel{}, errPage
+	}
+	if _, err := findBoundary(fetch, 10, 100, 1000); !errors.Is(err, errPage) {
+		t.Fatalf("got %v, want %v", err, errPage)
+	}
+}
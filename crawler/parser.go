@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/DRJ31/tiebarankgo/model"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/transform"
+)
+
+// RowError Error occurred while parsing one row of a furank page
+type RowError struct {
+	Index int
+	Field string
+	Err   error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: failed to parse %v: %v", e.Index, e.Field, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// ParseFurank Parse a GBK encoded furank page into users and the total number of members.
+// Rows that fail to parse are skipped, the first failure is returned as a *RowError
+// together with all the rows that were parsed successfully.
+func ParseFurank(r io.Reader) ([]model.TiebaUser, uint, error) {
+	// Ensure correct display of Chinese
+	utf8Reader := transform.NewReader(r, simplifiedchinese.GBK.NewDecoder())
+
+	// Create document from webpage
+	doc, err := goquery.NewDocumentFromReader(utf8Reader)
+	if err != nil {
+		log.Printf("New document err: %v", err)
+		return nil, 0, err
+	}
+
+	// Get total users
+	total, err := strconv.ParseUint(doc.Find(".drl_info_txt_gray").Text(), C.BASE, C.BITSIZE)
+	if err != nil {
+		log.Printf("Total parse err: %v", err)
+		total = 0
+	}
+
+	var rowErr error
+	users := make([]model.TiebaUser, 0, 20)
+	doc.Find(".drl_list_item").Each(func(i int, s *goquery.Selection) {
+		user, e := parseFurankRow(i, s)
+		if e != nil {
+			log.Println(e)
+			if rowErr == nil {
+				rowErr = e
+			}
+			return
+		}
+		users = append(users, user)
+	})
+
+	return users, uint(total), rowErr
+}
+
+func parseFurankRow(i int, s *goquery.Selection) (model.TiebaUser, error) {
+	// Check if the user is VIP
+	vip := s.Find(".drl_item_card").HasClass("drl_item_vip")
+
+	// Get Rank of user
+	rank, err := strconv.ParseUint(s.Find(".drl_item_index").Text(), C.BASE, C.BITSIZE)
+	if err != nil {
+		return model.TiebaUser{}, &RowError{i, "rank", err}
+	}
+
+	// Get experience value of user
+	exp, err := strconv.ParseUint(s.Find(".drl_item_exp").Text(), C.BASE, C.BITSIZE)
+	if err != nil {
+		return model.TiebaUser{}, &RowError{i, "exp", err}
+	}
+
+	// Get link of user
+	link, ok := s.Find(".drl_item_card").Find("a").Attr("href")
+	if !ok {
+		return model.TiebaUser{}, &RowError{i, "link", &MyError{"Failed to find link"}}
+	}
+
+	// Get level string of user
+	level, ok := s.Find(".drl_item_title").Find("div").Attr("class")
+	if !ok {
+		return model.TiebaUser{}, &RowError{i, "level", &MyError{"Failed to find level"}}
+	}
+	parts := strings.Split(level, "lv")
+	if len(parts) < 2 {
+		return model.TiebaUser{}, &RowError{i, "level", &MyError{"Unexpected level class " + level}}
+	}
+	lv, err := strconv.ParseUint(parts[1], C.BASE, C.BITSIZE)
+	if err != nil {
+		return model.TiebaUser{}, &RowError{i, "level", err}
+	}
+
+	return model.TiebaUser{
+		Rank:   uint(rank),
+		Member: vip,
+		Name:   s.Find(".drl_item_card").Text(),
+		Exp:    uint(exp),
+		Link:   link,
+		Level:  uint(lv),
+	}, nil
+}
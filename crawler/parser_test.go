@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+func TestParseFurank(t *testing.T) {
+	traveler := model.TiebaUser{Rank: 1, Member: true, Name: "旅行者", Exp: 123456, Link: "/home/main?un=旅行者", Level: 18}
+	paimon := model.TiebaUser{Rank: 2, Member: false, Name: "派蒙", Exp: 98765, Link: "/home/main?un=派蒙", Level: 17}
+	alice := model.TiebaUser{Rank: 3, Member: true, Name: "alice", Exp: 54321, Link: "/home/main?un=alice", Level: 16}
+
+	tests := []struct {
+		name     string
+		file     string
+		users    []model.TiebaUser
+		total    uint
+		rowIndex int // Index of the row failing to parse, -1 when all rows are parsed
+		rowField string
+	}{
+		{"normal rows", "furank.html", []model.TiebaUser{traveler, paimon, alice}, 1234567, -1, ""},
+		{"malformed row", "furank_malformed.html", []model.TiebaUser{traveler, alice}, 1234567, 1, "exp"},
+		{"missing total", "furank_no_total.html", []model.TiebaUser{traveler}, 0, -1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			users, total, err := ParseFurank(f)
+			if tt.rowIndex < 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				var rowErr *RowError
+				if !errors.As(err, &rowErr) {
+					t.Fatalf("expected *RowError, got %v", err)
+				}
+				if rowErr.Index != tt.rowIndex || rowErr.Field != tt.rowField {
+					t.Errorf("got error on row %d field %v, want row %d field %v",
+						rowErr.Index, rowErr.Field, tt.rowIndex, tt.rowField)
+				}
+			}
+			if total != tt.total {
+				t.Errorf("got total %d, want %d", total, tt.total)
+			}
+			if !reflect.DeepEqual(users, tt.users) {
+				t.Errorf("got users %+v, want %+v", users, tt.users)
+			}
+		})
+	}
+}
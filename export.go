@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/crawler"
+	"github.com/DRJ31/tiebarankgo/export"
+	"github.com/DRJ31/tiebarankgo/model"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+)
+
+// runExport Run export subcommand: export [flags] users|posts|history|income
+func runExport(store model.Store, cf config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", export.FormatCSV, "format of the output, csv or xlsx")
+	forumKey := fs.String("forum", "", "key of the forum, the first configured forum by default")
+	fromDate := fs.String("from", "", "first day included, formatted as "+C.DATEFMT)
+	toDate := fs.String("to", "", "last day included, formatted as "+C.DATEFMT)
+	columns := fs.String("columns", "", "comma separated columns to export, all columns by default")
+	output := fs.String("o", "", "file to write, stdout by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: export [flags] %v", strings.Join(export.Tables, "|"))
+	}
+
+	if !export.ValidFormat(*format) {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	forum, ok := cf.GetForum(*forumKey)
+	if !ok {
+		return fmt.Errorf("unknown forum %q", *forumKey)
+	}
+	var from, to time.Time
+	var err error
+	if *fromDate != "" {
+		if from, err = time.ParseInLocation(C.DATEFMT, *fromDate, time.Local); err != nil {
+			return err
+		}
+	}
+	if *toDate != "" {
+		if to, err = time.ParseInLocation(C.DATEFMT, *toDate, time.Local); err != nil {
+			return err
+		}
+	}
+
+	source := export.Source{
+		Store: store,
+		Income: func(from, to time.Time) (model.IncomeData, error) {
+			return crawler.GetIncomeData(context.Background(), from, to)
+		},
+	}
+	t, err := source.Open(export.Query{
+		Table:   fs.Arg(0),
+		Forum:   forum.Key,
+		From:    from,
+		To:      to,
+		Columns: export.ParseColumns(*columns),
+	})
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(out)
+	err = export.Write(w, *format, t)
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	// Partial output is not left behind
+	if err != nil && *output != "" {
+		os.Remove(*output)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats of exported tables
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Column Column of a table, numeric columns are written as numbers in XLSX
+type Column struct {
+	Name    string
+	Numeric bool
+}
+
+// Table Columns and rows of a table, rows are produced one by one so large tables are not held in memory
+type Table struct {
+	Name    string
+	Columns []Column
+	// Rows Call emit on each row, stopping at the first error
+	Rows func(emit func(row []string) error) error
+}
+
+// ColumnNames Names of columns of t
+func (t Table) ColumnNames() []string {
+	names := make([]string, 0, len(t.Columns))
+	for _, column := range t.Columns {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+// Select Keep columns named in names in their order, all columns are kept when names is empty
+func (t Table) Select(names []string) (Table, error) {
+	if len(names) == 0 {
+		return t, nil
+	}
+
+	indexes := make([]int, 0, len(names))
+	columns := make([]Column, 0, len(names))
+	for _, name := range names {
+		index := -1
+		for i, column := range t.Columns {
+			if column.Name == name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return Table{}, fmt.Errorf("%w: unknown column %q of %v, expected %v",
+				ErrBadQuery, name, t.Name, strings.Join(t.ColumnNames(), ","))
+		}
+		indexes = append(indexes, index)
+		columns = append(columns, t.Columns[index])
+	}
+
+	rows := t.Rows
+	return Table{
+		Name:    t.Name,
+		Columns: columns,
+		Rows: func(emit func(row []string) error) error {
+			return rows(func(row []string) error {
+				selected := make([]string, len(indexes))
+				for i, index := range indexes {
+					selected[i] = row[index]
+				}
+				return emit(selected)
+			})
+		},
+	}, nil
+}
+
+// ValidFormat Whether format is supported
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatXLSX
+}
+
+// ContentType MIME type of format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Write Write header and rows of t to w in format
+func Write(w io.Writer, format string, t Table) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, t)
+	case FormatXLSX:
+		return writeXLSX(w, t)
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrBadQuery, format)
+	}
+}
+
+// csvFlushRows Rows buffered before they are flushed to the client
+const csvFlushRows = 1000
+
+func writeCSV(w io.Writer, t Table) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.ColumnNames()); err != nil {
+		return err
+	}
+
+	count := 0
+	err := t.Rows(func(row []string) error {
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+		count++
+		if count%csvFlushRows == 0 {
+			cw.Flush()
+			return cw.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,195 @@
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/model"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+)
+
+// ErrBadQuery Query of an export is invalid
+var ErrBadQuery = errors.New("invalid export")
+
+// Tables that can be exported
+const (
+	TableUsers   = "users"
+	TablePosts   = "posts"
+	TableHistory = "history"
+	TableIncome  = "income"
+)
+
+// Tables Names of all tables
+var Tables = []string{TableUsers, TablePosts, TableHistory, TableIncome}
+
+// IncomeStart Date of the first income of the game
+var IncomeStart = time.Date(2020, 9, 28, 0, 0, 0, 0, time.Local)
+
+// Query Table, rows and columns to export
+type Query struct {
+	Table   string
+	Forum   string    // Key of the forum
+	From    time.Time // First day included, zero for no limit
+	To      time.Time // Last day included, zero for no limit
+	Columns []string  // All columns when empty
+}
+
+// Source Data of tables
+type Source struct {
+	Store model.Store
+	// Income Get daily income from chandashi, it is called before the table is returned
+	Income func(from, to time.Time) (model.IncomeData, error)
+}
+
+// ParseColumns Parse a comma separated list of column names
+func ParseColumns(value string) []string {
+	if value == "" {
+		return nil
+	}
+	columns := strings.Split(value, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	return columns
+}
+
+// Open Get table of q, errors of invalid queries wrap ErrBadQuery
+func (s Source) Open(q Query) (Table, error) {
+	if !q.From.IsZero() && !q.To.IsZero() && q.From.After(q.To) {
+		return Table{}, fmt.Errorf("%w: from is after to", ErrBadQuery)
+	}
+
+	var t Table
+	switch q.Table {
+	case TableUsers:
+		if !q.From.IsZero() || !q.To.IsZero() {
+			return Table{}, fmt.Errorf("%w: users have no dates", ErrBadQuery)
+		}
+		t = s.users(q.Forum)
+	case TablePosts:
+		t = s.posts(q.Forum, q.From, q.To)
+	case TableHistory:
+		t = s.history(q.Forum, q.From, q.To)
+	case TableIncome:
+		from, to := q.From, q.To
+		if from.IsZero() {
+			from = IncomeStart
+		}
+		if to.IsZero() {
+			to = time.Now()
+		}
+		data, err := s.Income(from, to)
+		if err != nil {
+			return Table{}, err
+		}
+		t = income(data)
+	default:
+		return Table{}, fmt.Errorf("%w: unknown table %q, expected %v", ErrBadQuery, q.Table, strings.Join(Tables, ","))
+	}
+
+	return t.Select(q.Columns)
+}
+
+func formatUint(n uint) string {
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+func (s Source) users(forum string) Table {
+	return Table{
+		Name: TableUsers,
+		Columns: []Column{
+			{"rank", true}, {"name", false}, {"nickname", false}, {"level", true},
+			{"exp", true}, {"member", false}, {"link", false},
+		},
+		Rows: func(emit func(row []string) error) error {
+			return s.Store.EachUser(forum, func(user model.User) error {
+				return emit([]string{
+					formatUint(user.Rank), user.Name, user.Nickname, formatUint(user.Level),
+					formatUint(user.Exp), strconv.FormatBool(user.Member), user.Link,
+				})
+			})
+		},
+	}
+}
+
+func (s Source) posts(forum string, from, to time.Time) Table {
+	return Table{
+		Name: TablePosts,
+		Columns: []Column{
+			{"date", false}, {"total", true}, {"followers", true}, {"members", true}, {"vip", true}, {"signin", true},
+		},
+		Rows: func(emit func(row []string) error) error {
+			posts, err := s.Store.GetPostsBetween(forum, from, to)
+			if err != nil {
+				return err
+			}
+			for _, post := range posts {
+				err = emit([]string{
+					post.Date.Format(C.DATEFMT), formatUint(post.Total), formatUint(post.Followers),
+					formatUint(post.Members), formatUint(post.Vip), formatUint(post.Signin),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// history Users of each level by day, decoded from distributions saved as JSON
+func (s Source) history(forum string, from, to time.Time) Table {
+	return Table{
+		Name:    TableHistory,
+		Columns: []Column{{"date", false}, {"level", true}, {"users", true}},
+		Rows: func(emit func(row []string) error) error {
+			histories, err := s.Store.GetHistories(forum, from, to)
+			if err != nil {
+				return err
+			}
+			for _, history := range histories {
+				var dist map[uint]uint
+				if err = json.Unmarshal([]byte(history.Distribution), &dist); err != nil {
+					return fmt.Errorf("history %d: %w", history.Id, err)
+				}
+				levels := make([]uint, 0, len(dist))
+				for level := range dist {
+					levels = append(levels, level)
+				}
+				sort.Slice(levels, func(i, j int) bool {
+					return levels[i] > levels[j]
+				})
+
+				day := history.Date.Format(C.DATEFMT)
+				for _, level := range levels {
+					if err = emit([]string{day, formatUint(level), formatUint(dist[level])}); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// income Daily income of the game
+func income(data model.IncomeData) Table {
+	return Table{
+		Name:    TableIncome,
+		Columns: []Column{{"date", false}, {"income", true}},
+		Rows: func(emit func(row []string) error) error {
+			for _, point := range data.Series(0) {
+				day := time.Unix(int64(point[0])/1000, 0).Format(C.DATEFMT)
+				if err := emit([]string{day, formatUint(point[1])}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
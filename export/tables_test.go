@@ -0,0 +1,36 @@
+package export
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+func TestIncomeShortPairs(t *testing.T) {
+	var data model.IncomeData
+	body := `{"data":{"points":[{"data":[[1614556800000,10],[1614643200000],[]]}]}}`
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatal(err)
+	}
+	source := Source{Income: func(from, to time.Time) (model.IncomeData, error) {
+		return data, nil
+	}}
+
+	table, err := source.Open(Query{Table: TableIncome})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows [][]string
+	err = table.Rows(func(row []string) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0][1] != "10" {
+		t.Errorf("got rows %v, want one row of income 10", rows)
+	}
+}
@@ -0,0 +1,110 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// Parts of a workbook with a single sheet, the sheet itself is written by writeXLSX
+var xlsxParts = []struct {
+	Name    string
+	Content string
+}{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// xlsxSheetName Name of the sheet, sheet names are limited to 31 characters
+func xlsxSheetName(name string) string {
+	runes := []rune(name)
+	if len(runes) > 31 {
+		runes = runes[:31]
+	}
+	return string(runes)
+}
+
+// writeXLSX Write t as a workbook, rows are streamed into the sheet as inline strings and numbers
+func writeXLSX(w io.Writer, t Table) error {
+	zw := zip.NewWriter(w)
+
+	for _, part := range xlsxParts {
+		fw, err := zw.Create(part.Name)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(fw, part.Content); err != nil {
+			return err
+		}
+	}
+
+	fw, err := zw.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(fw)
+	bw.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+	xml.EscapeText(bw, []byte(xlsxSheetName(t.Name)))
+	bw.WriteString(`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+
+	fw, err = zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	bw = bufio.NewWriter(fw)
+	bw.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]Column, len(t.Columns))
+	if err = writeXLSXRow(bw, header, t.ColumnNames()); err != nil {
+		return err
+	}
+	err = t.Rows(func(row []string) error {
+		return writeXLSXRow(bw, t.Columns, row)
+	})
+	if err != nil {
+		return err
+	}
+
+	bw.WriteString(`</sheetData></worksheet>`)
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeXLSXRow Write a row of a sheet, values of numeric columns that are not numbers are written as strings.
+// Errors of bw are sticky, so the error of the last write covers the whole row.
+func writeXLSXRow(bw *bufio.Writer, columns []Column, row []string) error {
+	bw.WriteString("<row>")
+	for i, value := range row {
+		if columns[i].Numeric {
+			if _, err := strconv.ParseFloat(value, 64); err == nil {
+				bw.WriteString("<c><v>")
+				bw.WriteString(value)
+				bw.WriteString("</v></c>")
+				continue
+			}
+		}
+		bw.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		xml.EscapeText(bw, []byte(value))
+		bw.WriteString("</t></is></c>")
+	}
+	_, err := bw.WriteString("</row>")
+	return err
+}
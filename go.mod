@@ -8,11 +8,14 @@ require (
 	github.com/go-redis/redis/v8 v8.8.2
 	github.com/gofiber/fiber/v2 v2.8.0
 	github.com/klauspost/compress v1.12.2 // indirect
-	github.com/valyala/fasthttp v1.24.0 // indirect
+	github.com/prometheus/client_golang v1.10.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/valyala/fasthttp v1.24.0
 	github.com/valyala/tcplisten v1.0.0 // indirect
 	go.opentelemetry.io/otel v0.20.0 // indirect
 	golang.org/x/sys v0.0.0-20210426230700-d19ff857e887 // indirect
 	golang.org/x/text v0.3.6
 	gorm.io/driver/mysql v1.0.6
+	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.21.9
 )
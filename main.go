@@ -1,36 +1,159 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"github.com/DRJ31/tiebarankgo/auth"
 	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/crawler"
+	"github.com/DRJ31/tiebarankgo/metrics"
+	"github.com/DRJ31/tiebarankgo/model"
 	"github.com/DRJ31/tiebarankgo/router"
+	"github.com/DRJ31/tiebarankgo/task"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func InitRouter(app *fiber.App) {
-	app.Get("/api/v2/tieba/users", router.GetUsers)
-	app.Get("/api/v2/tieba/event", router.GetEvent)
-	app.Get("/api/v2/tieba/anniversary", router.GetAnniversaries)
-	app.Get("/api/v2/tieba/events", router.GetEvents)
-	app.Get("/api/v2/tieba/post", router.GetOnePost)
-	app.Get("/api/v2/tieba/posts", router.GetMultiplePosts)
-	app.Get("/api/v2/tieba/user", router.FindUsers)
-	app.Get("/api/wallpaper", router.GetWallpaper)
-	//app.Get("/api/v2/tieba/distribution", router.GetDist)
-	app.Get("/api/v2/tieba/income", router.GetIncome)
-	app.Post("/api/v2/tieba/user", router.GetUser)
-	//app.Post("/api/v2/tieba/rank", router.GetRank)
-	app.Post("/api/v2/tieba/post", router.InsertPostInfo)
-	//app.Post("/api/v2/tieba/users", router.InsertUsers)
+	read := router.Auth(auth.ScopeRead)
+	ingest := router.Auth(auth.ScopeIngest)
+	admin := router.Auth(auth.ScopeAdmin)
+
+	app.Get("/api/v2/tieba/users", read, router.GetUsers)
+	app.Get("/api/v2/tieba/event", read, router.GetEvent)
+	app.Get("/api/v2/tieba/anniversary", read, router.GetAnniversaries)
+	app.Get("/api/v2/tieba/events", read, router.GetEvents)
+	app.Get("/api/v2/tieba/calendar.ics", router.RateLimit, router.GetCalendar)
+	app.Get("/api/v2/tieba/feed.xml", router.RateLimit, router.GetFeed)
+	app.Get("/api/v2/tieba/post", read, router.GetOnePost)
+	app.Get("/api/v2/tieba/posts", read, router.GetMultiplePosts)
+	app.Get("/api/v2/tieba/user", read, router.FindUsers)
+	app.Get("/api/v2/tieba/user/:name/history", read, router.GetUserHistory)
+	app.Get("/api/wallpaper", read, router.GetWallpaper)
+	app.Get("/api/v2/tieba/distribution", read, router.GetDist)
+	app.Get("/api/v2/tieba/income", read, router.GetIncome)
+	app.Get("/api/v2/tieba/export/:table", read, router.Export)
+	app.Get("/api/v2/tieba/schedule", read, router.GetSchedule)
+	app.Post("/api/v2/tieba/user", read, router.GetUser)
+	app.Post("/api/v2/tieba/rank", read, router.GetRank)
+	app.Post("/api/v2/tieba/post", ingest, router.InsertPostInfo)
+	app.Get("/api/v2/admin/crawl", admin, router.GetCrawl)
+	app.Post("/api/v2/admin/crawl/start", admin, router.StartCrawl)
+	app.Post("/api/v2/admin/crawl/stop", admin, router.StopCrawl)
+	app.Post("/api/v2/admin/event", admin, router.CreateEvent)
+	app.Put("/api/v2/admin/event/:id", admin, router.UpdateEvent)
+	app.Delete("/api/v2/admin/event/:id", admin, router.DeleteEvent)
+	app.Post("/api/v2/admin/events", admin, router.ImportEvents)
+	app.Post("/api/v2/admin/anniversary", admin, router.CreateAnniversary)
+	app.Put("/api/v2/admin/anniversary/:id", admin, router.UpdateAnniversary)
+	app.Delete("/api/v2/admin/anniversary/:id", admin, router.DeleteAnniversary)
+	app.Post("/api/v2/admin/anniversaries", admin, router.ImportAnniversaries)
+	app.Get("/api/v2/admin/banner", admin, router.GetBanners)
+	app.Post("/api/v2/admin/banner", admin, router.CreateBanner)
+	app.Put("/api/v2/admin/banner/:id", admin, router.UpdateBanner)
+	app.Delete("/api/v2/admin/banner/:id", admin, router.DeleteBanner)
+	app.Post("/api/v2/admin/banner/:id/refresh", admin, router.RefreshBanner)
+	app.Post("/api/v2/admin/banner/:id/final", admin, router.FinalizeBanner)
+	app.Get("/api/v2/admin/audit", admin, router.GetAuditLogs)
+}
+
+// InitWorkerRouter Routes served in worker mode, which only calculates distribution for other servers
+func InitWorkerRouter(app *fiber.App) {
+	app.Post("/api/v2/tieba/rank", router.Auth(auth.ScopeRead), router.GetRank)
 }
 
 func main() {
-	app := fiber.New()
+	configPath := flag.String("config", config.DefaultPath, "path of config file, empty to use only TIEBARANK_* environment variables")
+	flag.Parse()
+
+	cf, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: router.ErrorHandler,
+		ProxyHeader:  cf.ProxyHeader,
+	})
 	app.Use(cors.New())
 	app.Use(compress.New())
-	InitRouter(app)
-	cf := config.GetConfig()
-	_ = app.Listen(fmt.Sprintf("%v:%v", cf.Host, cf.Port))
+	app.Use(metrics.Middleware())
+	app.Use(recover.New(recover.Config{EnableStackTrace: true}))
+	app.Get("/metrics", metrics.Handler())
+	app.Get("/healthz", router.Healthz)
+	app.Get("/readyz", router.Readyz)
+
+	store, err := model.Open(cf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	switch flag.Arg(0) {
+	case "migrate":
+		if err = runMigrate(store, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "apikey":
+		if err = runAPIKey(store, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err = checkMigrations(store); err != nil {
+		log.Fatal(err)
+	}
+
+	cache, err := model.OpenCache(cf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cache.Close()
+	live := config.NewLive(cf)
+	go live.Watch(context.Background(), *configPath, 2*time.Second)
+	crawler.Setup(live, cache)
+	router.Setup(live, store, cache)
+	task.Setup(live, store, cache)
+
+	// Exports of income are crawled, so they need the crawler set up
+	if flag.Arg(0) == "export" {
+		if err = runExport(store, cf, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "worker" {
+		InitWorkerRouter(app)
+	} else {
+		InitRouter(app)
+		if err := task.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	app.Use(router.NotFound)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		<-sigCtx.Done()
+		log.Println("Shutting down")
+		shutdown(app)
+	}()
+
+	if err := app.Listen(fmt.Sprintf("%v:%v", cf.Host, cf.Port)); err != nil {
+		log.Fatal(err)
+	}
+	// Store and cache are closed once in-flight requests and crawls are done
+	<-stopped
 }
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+const namespace = "tiebarank"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests handled by route, method and status.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to handle requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	crawlerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "crawler_requests_total",
+		Help:      "Crawler requests by target, retries are included in a request.",
+	}, []string{"target"})
+	crawlerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "crawler_errors_total",
+		Help:      "Crawler requests failed with an error or status of at least 400 by target.",
+	}, []string{"target"})
+	crawlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "crawler_request_duration_seconds",
+		Help:      "Time to get responses of crawler requests by target.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"target"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by rate limits by route.",
+	}, []string{"route"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups by cache and result (hit, stale or miss).",
+	}, []string{"cache", "result"})
+
+	memberTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forum_members",
+		Help:      "Last known number of followers of a forum.",
+	}, []string{"forum"})
+	postTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "forum_posts",
+		Help:      "Last known number of posts of a forum.",
+	}, []string{"forum"})
+)
+
+// Middleware Count requests and their latency by route, errors are sent by the error handler of app first
+func Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		if err := c.Next(); err != nil {
+			if err = c.App().Config().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+
+		status := c.Response().StatusCode()
+		route := c.Route().Path
+		method := c.Method()
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// Handler Serve metrics in Prometheus format
+func Handler() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
+
+// ObserveCrawl Record a crawler request to target started at start
+func ObserveCrawl(target string, start time.Time, failed bool) {
+	crawlerRequests.WithLabelValues(target).Inc()
+	crawlerDuration.WithLabelValues(target).Observe(time.Since(start).Seconds())
+	if failed {
+		crawlerErrors.WithLabelValues(target).Inc()
+	}
+}
+
+// RateLimited Record a request to route rejected by its rate limit
+func RateLimited(route string) {
+	rateLimited.WithLabelValues(route).Inc()
+}
+
+// CacheLookup Record result of a lookup in cache, result is hit, stale or miss
+func CacheLookup(cache, result string) {
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// SetTotals Record the last known followers and posts of a forum
+func SetTotals(forum string, members, posts uint) {
+	memberTotal.WithLabelValues(forum).Set(float64(members))
+	postTotal.WithLabelValues(forum).Set(float64(posts))
+}
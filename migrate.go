@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+// runMigrate Run migrate subcommand: migrate up|down|status
+func runMigrate(store model.Migrator, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: migrate up|down|status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := store.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Applied %d %v\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		return err
+	case "down":
+		m, err := store.MigrateDown()
+		if errors.Is(err, model.ErrNotFound) {
+			fmt.Println("No applied migrations")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d %v\n", m.Version, m.Name)
+		return nil
+	case "status":
+		statuses, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, m := range statuses {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-32v %v\n", m.Version, m.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %v", args[0])
+	}
+}
+
+// checkMigrations Refuse to start with pending migrations, the schema would not match the models
+func checkMigrations(store model.Migrator) error {
+	statuses, err := store.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	pending := make([]string, 0)
+	for _, m := range statuses {
+		if m.AppliedAt == nil {
+			pending = append(pending, strconv.FormatUint(uint64(m.Version), 10))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("migrations %v are pending, run migrate up first", strings.Join(pending, ","))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// audit Record change of record id in entity made by actor, before or after is nil when it is created or deleted
+func audit(tx *gorm.DB, actor, action, entity string, id uint, before, after interface{}) error {
+	log := AuditLog{Actor: actor, Action: action, Entity: entity, EntityId: id, CreatedAt: time.Now()}
+	if before != nil {
+		data, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		log.Before = string(data)
+	}
+	if after != nil {
+		data, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		log.After = string(data)
+	}
+	return tx.Create(&log).Error
+}
+
+func (s *GormStore) GetAuditLogs(entity string, limit int) ([]AuditLog, error) {
+	var logs []AuditLog
+	query := s.DB.Order("id desc").Limit(limit)
+	if entity != "" {
+		query = query.Where("entity = ?", entity)
+	}
+	res := query.Find(&logs)
+	return logs, res.Error
+}
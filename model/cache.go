@@ -0,0 +1,42 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+)
+
+// ErrCacheMiss Returned by Cache.Get when the key does not exist or has expired
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache Key value cache, values are encoded as JSON
+type Cache interface {
+	// Get Decode value of key into v, ErrCacheMiss is returned when it is not cached
+	Get(ctx context.Context, key string, v interface{}) error
+	// Set Cache v under key, a ttl of 0 keeps it until it is deleted or evicted
+	Set(ctx context.Context, key string, v interface{}, ttl time.Duration) error
+	// SetNX Cache v under key only if it does not exist, reports whether it was set
+	SetNX(ctx context.Context, key string, v interface{}, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, keys ...string) error
+	// DeleteValue Delete key only if it still holds v, reports whether it was deleted
+	DeleteValue(ctx context.Context, key string, v interface{}) (bool, error)
+	// Incr Add n to the integer under key, a missing key counts as 0. Keys without ttl are given ttl
+	Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// OpenCache Open cache selected in config, redis by default
+func OpenCache(cf config.Config) (Cache, error) {
+	switch cf.Cache {
+	case "", "redis":
+		return NewRedisCache(cf), nil
+	case "memory":
+		return NewMemoryCache(cf.CacheSize), nil
+	default:
+		return nil, fmt.Errorf("unknown cache %v", cf.Cache)
+	}
+}
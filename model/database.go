@@ -1,27 +1,35 @@
 package model
 
 import (
-	"fmt"
-	"github.com/DRJ31/tiebarankgo/config"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 	"time"
 )
 
 type User struct {
 	Id       uint   `json:"id"`
+	Forum    string `json:"forum" gorm:"size:32;not null;default:genshin;uniqueIndex:idx_user_forum_name;uniqueIndex:idx_user_forum_link"` // Rows older than forum support have config.DefaultForum.Key
 	Rank     uint   `json:"rank"`
-	Name     string `json:"name"`
-	Nickname string `json:"nickname"`
-	Link     string `json:"link"`
+	Name     string `json:"name" gorm:"size:128;uniqueIndex:idx_user_forum_name"`
+	Nickname string `json:"nickname" gorm:"size:128"`
+	Link     string `json:"link" gorm:"size:255;uniqueIndex:idx_user_forum_link"`
 	Level    uint   `json:"level"`
 	Exp      uint   `json:"exp"`
 	Member   bool   `json:"member"`
 }
 
+type UserSnapshot struct {
+	Id     uint      `json:"id"`
+	Forum  string    `json:"forum" gorm:"size:32;index:idx_user_snapshot_forum_name_date"`
+	Name   string    `json:"name" gorm:"size:128;index:idx_user_snapshot_forum_name_date"`
+	Date   time.Time `json:"date" gorm:"index:idx_user_snapshot_forum_name_date"`
+	Rank   uint      `json:"rank"`
+	Level  uint      `json:"level"`
+	Exp    uint      `json:"exp"`
+	Member bool      `json:"member"`
+}
+
 type Anniversary struct {
 	Id          uint   `json:"id"`
-	Date        string `json:"date"`
+	Date        string `json:"date" gorm:"size:16;index:idx_anniversary_date"` // First occurrence, repeated yearly
 	Event       string `json:"event"`
 	Adj         string `json:"adj"`
 	Description string `json:"description"`
@@ -29,13 +37,14 @@ type Anniversary struct {
 
 type Event struct {
 	Id    uint      `json:"id"`
-	Date  time.Time `json:"date"`
+	Date  time.Time `json:"date" gorm:"index:idx_event_date"`
 	Event string    `json:"event"`
 }
 
 type Post struct {
 	Id        uint      `json:"id"`
-	Date      time.Time `json:"date"`
+	Forum     string    `json:"forum" gorm:"size:32;not null;default:genshin;index:idx_post_forum_date"` // Default as User.Forum
+	Date      time.Time `json:"date" gorm:"index:idx_post_forum_date"`
 	Total     uint      `json:"total"`
 	Followers uint      `json:"followers"`
 	Members   uint      `json:"members"`
@@ -45,29 +54,65 @@ type Post struct {
 
 type History struct {
 	Id           uint      `json:"id"`
-	Date         time.Time `json:"date"`
+	Forum        string    `json:"forum" gorm:"size:32;not null;default:genshin;index:idx_history_forum_date"` // Default as User.Forum
+	Date         time.Time `json:"date" gorm:"index:idx_history_forum_date"`
 	Distribution string    `json:"distribution"`
 }
 
 type Divider struct {
-	Id    uint `json:"id"`
-	Level uint `json:"level"`
-	Rank  uint `json:"rank"`
+	Id    uint   `json:"id"`
+	Forum string `json:"forum" gorm:"size:32;not null;default:genshin;uniqueIndex:idx_divider_forum_level"` // Default as User.Forum
+	Level uint   `json:"level" gorm:"uniqueIndex:idx_divider_forum_level"`
+	Rank  uint   `json:"rank"`
 }
 
 type UpIncome struct {
 	Id     uint      `json:"id"`
-	Name   string    `json:"name"`
-	Date   time.Time `json:"date"`
+	Name   string    `json:"name" gorm:"size:128"`
+	Date   time.Time `json:"date" gorm:"index:idx_income_date"`
 	Income uint      `json:"income"`
 	Max    uint      `json:"max"`
 	Short  string    `json:"short"`
+	Final  bool      `json:"final"` // Income and Max are no longer refreshed
+}
+
+// APIKey Key issued to a client, requests are signed with Secret
+type APIKey struct {
+	Id        uint       `json:"id"`
+	KeyId     string     `json:"key_id" gorm:"size:32;uniqueIndex:idx_api_key_key_id"`
+	Secret    string     `json:"-" gorm:"size:64"`
+	Name      string     `json:"name" gorm:"size:128"`
+	Scopes    string     `json:"scopes" gorm:"size:64"` // Comma separated, e.g. read,ingest
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// JobRun Last successful run of a scheduled job
+type JobRun struct {
+	Name    string    `json:"name" gorm:"primaryKey;size:32"`
+	LastRun time.Time `json:"last_run"` // Scheduled time of the run
+}
+
+// AuditLog Change of a record made by an admin, records are stored as JSON
+type AuditLog struct {
+	Id        uint      `json:"id"`
+	Actor     string    `json:"actor" gorm:"size:160"` // Name and key id of the API key
+	Action    string    `json:"action" gorm:"size:16"`
+	Entity    string    `json:"entity" gorm:"size:32;index:idx_audit_log_entity"` // Table of the record
+	EntityId  uint      `json:"entity_id" gorm:"index:idx_audit_log_entity"`
+	Before    string    `json:"before" gorm:"type:text"` // Empty when created
+	After     string    `json:"after" gorm:"type:text"`  // Empty when deleted
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_audit_log_created_at"`
 }
 
 func (User) TableName() string {
 	return "user"
 }
 
+func (UserSnapshot) TableName() string {
+	return "user_snapshot"
+}
+
 func (Anniversary) TableName() string {
 	return "anniversary"
 }
@@ -92,23 +137,14 @@ func (UpIncome) TableName() string {
 	return "income"
 }
 
-func Init() (*gorm.DB, error) {
-	formatStr := "%v:%v@tcp(%v:%v)/%v?charset=utf8mb4&parseTime=True&loc=Local"
-	cf := config.GetConfig()
-	dsn := fmt.Sprintf(formatStr, cf.Username, cf.Password, cf.DBHost, cf.DBPort, cf.Database)
-	Conn, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
-	if err != nil {
-		return nil, err
-	}
-
-	return Conn, nil
+func (APIKey) TableName() string {
+	return "api_key"
 }
 
-func Close(db *gorm.DB) error {
-	sqlDB, err := db.DB()
-	if err != nil {
-		return err
-	}
+func (JobRun) TableName() string {
+	return "job_run"
+}
 
-	return sqlDB.Close()
+func (AuditLog) TableName() string {
+	return "audit_log"
 }
@@ -0,0 +1,542 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// eachUserPage Users read at once by EachUser
+const eachUserPage = 1000
+
+// GormStore Store backed by gorm, shared by MySQL and SQLite
+type GormStore struct {
+	DB *gorm.DB
+}
+
+// dayRange Get the start of the date of day and the start of the next date in local time
+func dayRange(day time.Time) (time.Time, time.Time) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	return start, start.AddDate(0, 0, 1)
+}
+
+func (s *GormStore) Ping(ctx context.Context) error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+func (s *GormStore) Close() error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
+func (s *GormStore) GetUser(forum, name string) (User, error) {
+	var user User
+	res := s.DB.First(&user, "forum = ? AND name = ?", forum, name)
+	return user, res.Error
+}
+
+func (s *GormStore) GetUserByLink(forum, link string) (User, error) {
+	var user User
+	res := s.DB.First(&user, "forum = ? AND link = ?", forum, link)
+	return user, res.Error
+}
+
+func (s *GormStore) FindUsers(forum, keyword string) ([]User, error) {
+	var users []User
+	keyword = "%" + keyword + "%"
+	res := s.DB.Where("forum = ?", forum).Where(s.DB.Where("name LIKE ?", keyword).Or("nickname LIKE ?", keyword)).Find(&users)
+	return users, res.Error
+}
+
+func (s *GormStore) TopUser(forum string) (User, error) {
+	var user User
+	res := s.DB.Where("forum = ?", forum).Order("level desc").First(&user)
+	return user, res.Error
+}
+
+func (s *GormStore) CountVip(forum string) (int64, error) {
+	var count int64
+	res := s.DB.Model(&User{}).Where("forum = ? AND member = ?", forum, true).Count(&count)
+	return count, res.Error
+}
+
+func (s *GormStore) SaveUsers(forum string, users []TiebaUser, at time.Time) error {
+	uss := make([]User, 0, len(users))
+	for _, user := range users {
+		oldUser, err := s.GetUser(forum, user.Name)
+		if errors.Is(err, ErrNotFound) {
+			uss = append(uss, User{
+				Forum:    forum,
+				Rank:     user.Rank,
+				Level:    user.Level,
+				Exp:      user.Exp,
+				Member:   user.Member,
+				Link:     user.Link,
+				Name:     user.Name,
+				Nickname: user.Nickname,
+			})
+		} else if err != nil {
+			return err
+		} else {
+			s.DB.Model(&oldUser).Updates(User{
+				Rank:     user.Rank,
+				Level:    user.Level,
+				Exp:      user.Exp,
+				Member:   user.Member,
+				Nickname: user.Nickname,
+			})
+		}
+	}
+	if len(uss) > 0 {
+		if res := s.DB.Create(&uss); res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return s.saveUserSnapshots(forum, users, at)
+}
+
+// saveUserSnapshots Record ranking of users, users unchanged since their last snapshot are skipped
+func (s *GormStore) saveUserSnapshots(forum string, users []TiebaUser, at time.Time) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+
+	// Latest snapshot of each user, found with index idx_user_snapshot_forum_name_date
+	latestDates := s.DB.Model(&UserSnapshot{}).Select("name, MAX(date) AS date").
+		Where("forum = ? AND name IN ?", forum, names).Group("name")
+	var snapshots []UserSnapshot
+	res := s.DB.Table("user_snapshot AS s").Select("s.*").
+		Joins("JOIN (?) AS l ON s.name = l.name AND s.date = l.date", latestDates).
+		Where("s.forum = ?", forum).Find(&snapshots)
+	if res.Error != nil {
+		return res.Error
+	}
+	latest := make(map[string]UserSnapshot)
+	for _, snapshot := range snapshots {
+		latest[snapshot.Name] = snapshot
+	}
+
+	created := make([]UserSnapshot, 0, len(users))
+	for _, user := range users {
+		last, ok := latest[user.Name]
+		if ok && last.Rank == user.Rank && last.Level == user.Level && last.Exp == user.Exp && last.Member == user.Member {
+			continue
+		}
+		created = append(created, UserSnapshot{
+			Forum:  forum,
+			Name:   user.Name,
+			Date:   at,
+			Rank:   user.Rank,
+			Level:  user.Level,
+			Exp:    user.Exp,
+			Member: user.Member,
+		})
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	return s.DB.Create(&created).Error
+}
+
+func (s *GormStore) UpdateNickname(user User, nickname string) error {
+	return s.DB.Model(&user).Updates(User{Nickname: nickname}).Error
+}
+
+func (s *GormStore) GetUserSnapshots(forum, name string) ([]UserSnapshot, error) {
+	var snapshots []UserSnapshot
+	res := s.DB.Where("forum = ? AND name = ?", forum, name).Order("date").Find(&snapshots)
+	return snapshots, res.Error
+}
+
+func (s *GormStore) EachUser(forum string, fn func(user User) error) error {
+	// Pages are read whole, so no connection is held while fn is slow
+	for offset := 0; ; offset += eachUserPage {
+		var users []User
+		err := s.DB.Where("forum = ?", forum).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: "rank"}}).Order("id").
+			Limit(eachUserPage).Offset(offset).Find(&users).Error
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err = fn(user); err != nil {
+				return err
+			}
+		}
+		if len(users) < eachUserPage {
+			return nil
+		}
+	}
+}
+
+// between Limit query to dates from day from to day to, zero days are not limited
+func between(query *gorm.DB, from, to time.Time) *gorm.DB {
+	if !from.IsZero() {
+		start, _ := dayRange(from)
+		query = query.Where("date >= ?", start)
+	}
+	if !to.IsZero() {
+		_, end := dayRange(to)
+		query = query.Where("date < ?", end)
+	}
+	return query
+}
+
+func (s *GormStore) GetEvents() ([]Event, error) {
+	var events []Event
+	res := s.DB.Find(&events)
+	return events, res.Error
+}
+
+func (s *GormStore) GetEventsOn(day time.Time) ([]Event, error) {
+	var events []Event
+	start, end := dayRange(day)
+	res := s.DB.Find(&events, "date >= ? AND date < ?", start, end)
+	return events, res.Error
+}
+
+func (s *GormStore) GetAnniversaries() ([]Anniversary, error) {
+	var anniversaries []Anniversary
+	res := s.DB.Find(&anniversaries)
+	return anniversaries, res.Error
+}
+
+// duplicateEvent Check whether another event with the same text exists on the day of event
+func duplicateEvent(tx *gorm.DB, event Event) error {
+	var count int64
+	start, end := dayRange(event.Date)
+	res := tx.Model(&Event{}).Where("date >= ? AND date < ? AND event = ? AND id <> ?", start, end, event.Event, event.Id).Count(&count)
+	if res.Error != nil {
+		return res.Error
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: event %v on %v", ErrDuplicate, event.Event, event.Date.Format("2006-01-02"))
+	}
+	return nil
+}
+
+func (s *GormStore) CreateEvents(events []Event, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range events {
+			if err := duplicateEvent(tx, events[i]); err != nil {
+				return err
+			}
+			if err := tx.Create(&events[i]).Error; err != nil {
+				return err
+			}
+			if err := audit(tx, actor, AuditCreate, Event{}.TableName(), events[i].Id, nil, events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *GormStore) UpdateEvent(event *Event, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var before Event
+		if err := tx.First(&before, event.Id).Error; err != nil {
+			return err
+		}
+		if err := duplicateEvent(tx, *event); err != nil {
+			return err
+		}
+		if err := tx.Save(event).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditUpdate, Event{}.TableName(), event.Id, before, event)
+	})
+}
+
+func (s *GormStore) DeleteEvent(id uint, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var before Event
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&before).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditDelete, Event{}.TableName(), id, before, nil)
+	})
+}
+
+// duplicateAnniversary Check whether another anniversary with the same text exists on the date of anniversary
+func duplicateAnniversary(tx *gorm.DB, anniversary Anniversary) error {
+	var count int64
+	res := tx.Model(&Anniversary{}).
+		Where("date = ? AND event = ? AND id <> ?", anniversary.Date, anniversary.Event, anniversary.Id).
+		Count(&count)
+	if res.Error != nil {
+		return res.Error
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: anniversary %v on %v", ErrDuplicate, anniversary.Event, anniversary.Date)
+	}
+	return nil
+}
+
+func (s *GormStore) CreateAnniversaries(anniversaries []Anniversary, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range anniversaries {
+			if err := duplicateAnniversary(tx, anniversaries[i]); err != nil {
+				return err
+			}
+			if err := tx.Create(&anniversaries[i]).Error; err != nil {
+				return err
+			}
+			err := audit(tx, actor, AuditCreate, Anniversary{}.TableName(), anniversaries[i].Id, nil, anniversaries[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *GormStore) UpdateAnniversary(anniversary *Anniversary, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var before Anniversary
+		if err := tx.First(&before, anniversary.Id).Error; err != nil {
+			return err
+		}
+		if err := duplicateAnniversary(tx, *anniversary); err != nil {
+			return err
+		}
+		if err := tx.Save(anniversary).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditUpdate, Anniversary{}.TableName(), anniversary.Id, before, anniversary)
+	})
+}
+
+func (s *GormStore) DeleteAnniversary(id uint, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var before Anniversary
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&before).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditDelete, Anniversary{}.TableName(), id, before, nil)
+	})
+}
+
+func (s *GormStore) GetPosts(forum string) ([]Post, error) {
+	var posts []Post
+	res := s.DB.Where("forum = ?", forum).Order("date desc").Find(&posts)
+	return posts, res.Error
+}
+
+func (s *GormStore) GetPost(forum string, day time.Time) (Post, error) {
+	var post Post
+	start, end := dayRange(day)
+	res := s.DB.First(&post, "forum = ? AND date >= ? AND date < ?", forum, start, end)
+	return post, res.Error
+}
+
+func (s *GormStore) CreatePost(post *Post, history *History) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		start, end := dayRange(post.Date)
+		res := tx.Model(&Post{}).Where("forum = ? AND date >= ? AND date < ?", post.Forum, start, end).Count(&count)
+		if res.Error != nil {
+			return res.Error
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: post of %v on %v", ErrDuplicate, post.Forum, post.Date.Format("2006-01-02"))
+		}
+		if err := tx.Create(post).Error; err != nil {
+			return err
+		}
+		return tx.Create(history).Error
+	})
+}
+
+func (s *GormStore) GetHistory(forum string, day time.Time) (History, error) {
+	var history History
+	start, end := dayRange(day)
+	res := s.DB.First(&history, "forum = ? AND date >= ? AND date < ?", forum, start, end)
+	return history, res.Error
+}
+
+func (s *GormStore) GetPostsBetween(forum string, from, to time.Time) ([]Post, error) {
+	var posts []Post
+	res := between(s.DB.Where("forum = ?", forum), from, to).Order("date").Find(&posts)
+	return posts, res.Error
+}
+
+func (s *GormStore) GetHistories(forum string, from, to time.Time) ([]History, error) {
+	var histories []History
+	res := between(s.DB.Where("forum = ?", forum), from, to).Order("date").Find(&histories)
+	return histories, res.Error
+}
+
+func (s *GormStore) GetIncomes() ([]UpIncome, error) {
+	var incomes []UpIncome
+	res := s.DB.Find(&incomes)
+	return incomes, res.Error
+}
+
+func (s *GormStore) GetIncomesOn(day time.Time) ([]UpIncome, error) {
+	var incomes []UpIncome
+	start, end := dayRange(day)
+	res := s.DB.Find(&incomes, "date >= ? AND date < ?", start, end)
+	return incomes, res.Error
+}
+
+func (s *GormStore) GetIncomesBefore(day time.Time) ([]UpIncome, error) {
+	var incomes []UpIncome
+	start, _ := dayRange(day)
+	res := s.DB.Find(&incomes, "date < ?", start)
+	return incomes, res.Error
+}
+
+func (s *GormStore) SaveIncomes(incomes []UpIncome) error {
+	if len(incomes) == 0 {
+		return nil
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		for _, income := range incomes {
+			res := tx.Model(&UpIncome{}).Where("id = ? AND final = ?", income.Id, false).
+				Updates(map[string]interface{}{"income": income.Income, "max": income.Max})
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+		return nil
+	})
+}
+
+func (s *GormStore) GetIncome(id uint) (UpIncome, error) {
+	var income UpIncome
+	res := s.DB.First(&income, id)
+	return income, res.Error
+}
+
+// duplicateIncome Check whether another banner with the same name starts on the day of income
+func duplicateIncome(tx *gorm.DB, income UpIncome) error {
+	var count int64
+	start, end := dayRange(income.Date)
+	res := tx.Model(&UpIncome{}).Where("date >= ? AND date < ? AND name = ? AND id <> ?", start, end, income.Name, income.Id).Count(&count)
+	if res.Error != nil {
+		return res.Error
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: banner %v on %v", ErrDuplicate, income.Name, income.Date.Format("2006-01-02"))
+	}
+	return nil
+}
+
+func (s *GormStore) CreateIncome(income *UpIncome, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := duplicateIncome(tx, *income); err != nil {
+			return err
+		}
+		if err := tx.Create(income).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditCreate, UpIncome{}.TableName(), income.Id, nil, income)
+	})
+}
+
+func (s *GormStore) UpdateIncome(income *UpIncome, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var before UpIncome
+		if err := tx.First(&before, income.Id).Error; err != nil {
+			return err
+		}
+		if err := duplicateIncome(tx, *income); err != nil {
+			return err
+		}
+		if err := tx.Save(income).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditUpdate, UpIncome{}.TableName(), income.Id, before, income)
+	})
+}
+
+func (s *GormStore) DeleteIncome(id uint, actor string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var before UpIncome
+		if err := tx.First(&before, id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&before).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditDelete, UpIncome{}.TableName(), id, before, nil)
+	})
+}
+
+func (s *GormStore) GetDividers(forum string) ([]Divider, error) {
+	var dividers []Divider
+	res := s.DB.Where("forum = ?", forum).Order("level desc").Find(&dividers)
+	return dividers, res.Error
+}
+
+func (s *GormStore) CreateDivider(divider *Divider) error {
+	return s.DB.Create(divider).Error
+}
+
+func (s *GormStore) UpdateDivider(forum string, level, rank uint) error {
+	return s.DB.Model(&Divider{}).Where("forum = ? AND level = ?", forum, level).Update("rank", rank).Error
+}
+
+func (s *GormStore) GetJobRun(name string) (time.Time, error) {
+	var run JobRun
+	res := s.DB.First(&run, "name = ?", name)
+	return run.LastRun, res.Error
+}
+
+func (s *GormStore) SaveJobRun(name string, at time.Time) error {
+	return s.DB.Save(&JobRun{Name: name, LastRun: at}).Error
+}
+
+func (s *GormStore) GetAPIKey(keyId string) (APIKey, error) {
+	var key APIKey
+	res := s.DB.Where("key_id = ?", keyId).First(&key)
+	return key, res.Error
+}
+
+func (s *GormStore) ListAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	res := s.DB.Order("id").Find(&keys)
+	return keys, res.Error
+}
+
+func (s *GormStore) CreateAPIKey(key *APIKey) error {
+	return s.DB.Create(key).Error
+}
+
+func (s *GormStore) RevokeAPIKey(keyId string, at time.Time) error {
+	res := s.DB.Model(&APIKey{}).Where("key_id = ? AND revoked_at IS NULL", keyId).Update("revoked_at", at)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *GormStore {
+	t.Helper()
+	store, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSaveUsers(t *testing.T) {
+	store := newTestStore(t)
+	day := time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+
+	users := []TiebaUser{
+		{Rank: 1, Name: "alice", Link: "/alice", Level: 12, Exp: 100},
+		{Rank: 2, Name: "bob", Link: "/bob", Level: 11, Exp: 90},
+	}
+	if err := store.SaveUsers("genshin", users, day); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only alice changed, the same ranking saved again adds no snapshots
+	users[0].Exp = 120
+	if err := store.SaveUsers("genshin", users, day.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveUsers("genshin", users, day.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := store.GetUser("genshin", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Exp != 120 {
+		t.Errorf("got exp %d, want 120", alice.Exp)
+	}
+	if _, err = store.GetUser("other", "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v for user of another forum, want ErrNotFound", err)
+	}
+
+	for name, want := range map[string]int{"alice": 2, "bob": 1} {
+		snapshots, err := store.GetUserSnapshots("genshin", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != want {
+			t.Errorf("got %d snapshots of %v, want %d", len(snapshots), name, want)
+		}
+	}
+}
+
+func TestGetPost(t *testing.T) {
+	store := newTestStore(t)
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)
+
+	for _, post := range []Post{
+		{Forum: "genshin", Date: day.Add(23 * time.Hour), Total: 1},
+		{Forum: "genshin", Date: day.AddDate(0, 0, 1), Total: 2},
+		{Forum: "other", Date: day, Total: 3},
+	} {
+		post := post
+		if err := store.CreatePost(&post, &History{Forum: post.Forum, Date: post.Date}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A second post on the same day is rejected together with its history
+	err := store.CreatePost(&Post{Forum: "genshin", Date: day.Add(time.Hour)}, &History{Forum: "genshin", Date: day.Add(time.Hour)})
+	if !errors.Is(err, ErrDuplicate) {
+		t.Errorf("got %v for second post of a day, want ErrDuplicate", err)
+	}
+	if histories, _ := store.GetHistories("genshin", day, day); len(histories) != 1 {
+		t.Errorf("got %d histories of the day, want 1", len(histories))
+	}
+
+	tests := []struct {
+		name  string
+		forum string
+		day   time.Time
+		total uint
+	}{
+		{"start of day", "genshin", day, 1},
+		{"end of day", "genshin", day.Add(23*time.Hour + 59*time.Minute), 1},
+		{"next day", "genshin", day.AddDate(0, 0, 1).Add(time.Hour), 2},
+		{"other forum", "other", day.Add(time.Hour), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post, err := store.GetPost(tt.forum, tt.day)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if post.Total != tt.total {
+				t.Errorf("got total %d, want %d", post.Total, tt.total)
+			}
+		})
+	}
+
+	if _, err := store.GetPost("genshin", day.AddDate(0, 0, -1)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v for day without post, want ErrNotFound", err)
+	}
+}
+
+func TestSaveIncomes(t *testing.T) {
+	store := newTestStore(t)
+	day := time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)
+
+	open := UpIncome{Name: "open", Date: day}
+	final := UpIncome{Name: "final", Date: day, Income: 5, Max: 2, Final: true}
+	for _, income := range []*UpIncome{&open, &final} {
+		if err := store.CreateIncome(income, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open.Income, open.Max = 10, 4
+	final.Income, final.Max = 10, 4
+	if err := store.SaveIncomes([]UpIncome{open, final}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetIncome(open.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Income != 10 || got.Max != 4 {
+		t.Errorf("got income %d max %d of refreshed banner, want 10 and 4", got.Income, got.Max)
+	}
+	got, err = store.GetIncome(final.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Income != 5 || got.Max != 2 || !got.Final {
+		t.Errorf("got income %d max %d final %v of final banner, want 5, 2 and true", got.Income, got.Max, got.Final)
+	}
+}
+
+func TestEachUser(t *testing.T) {
+	store := newTestStore(t)
+	users := make([]User, 0, eachUserPage+1)
+	for i := eachUserPage + 1; i > 0; i-- {
+		name := strconv.Itoa(i)
+		users = append(users, User{Forum: "genshin", Rank: uint(i), Name: name, Link: "/" + name})
+	}
+	if err := store.DB.CreateInBatches(users, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var last uint
+	count := 0
+	err := store.EachUser("genshin", func(user User) error {
+		if user.Rank <= last {
+			t.Fatalf("got rank %d after %d", user.Rank, last)
+		}
+		last = user.Rank
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(users) {
+		t.Errorf("got %d users, want %d", count, len(users))
+	}
+}
@@ -0,0 +1,54 @@
+package model
+
+import "fmt"
+
+// Every cache key used by the server is built here, keys of a forum look like tieba_genshin_member_total
+
+func forumKey(forum, name string) string {
+	return fmt.Sprintf("tieba_%v_%v", forum, name)
+}
+
+// MemberTotalKey Number of users in the furank ranking of a forum
+func MemberTotalKey(forum string) string {
+	return forumKey(forum, "member_total")
+}
+
+// FollowerKey Number of followers shown on the front page of a forum
+func FollowerKey(forum string) string {
+	return forumKey(forum, "total")
+}
+
+// PostTotalKey Number of posts shown on the front page of a forum
+func PostTotalKey(forum string) string {
+	return forumKey(forum, "post_total")
+}
+
+// PageKey Users in a furank page of a forum
+func PageKey(forum string, page uint64) string {
+	return forumKey(forum, fmt.Sprintf("page_%d", page))
+}
+
+// PageLockKey Lock held by the instance crawling a furank page of a forum
+func PageLockKey(forum string, page uint64) string {
+	return forumKey(forum, fmt.Sprintf("page_%d_lock", page))
+}
+
+// DividerKey Level distribution of a forum calculated today
+func DividerKey(forum string) string {
+	return forumKey(forum, "divider")
+}
+
+// RosterKey Checkpoint of the roster crawl of a forum
+func RosterKey(forum string) string {
+	return forumKey(forum, "roster")
+}
+
+// SignatureKey Signature of a request already accepted, kept to reject replays
+func SignatureKey(keyId, signature string) string {
+	return fmt.Sprintf("tieba_signature_%v_%v", keyId, signature)
+}
+
+// RateLimitKey Requests of a client to a method and route in a rate limit window
+func RateLimitKey(route, client string, window int64) string {
+	return fmt.Sprintf("tieba_ratelimit_%v_%v_%d", route, client, window)
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize Number of keys kept by a memory cache when no size is configured
+const DefaultCacheSize = 10000
+
+type memoryEntry struct {
+	key     string
+	data    []byte
+	expires time.Time // Zero for keys without ttl
+}
+
+// MemoryCache Cache kept in process, least recently used keys are evicted when it is full
+type MemoryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Front is the most recently used
+	entries map[string]*list.Element
+}
+
+// NewMemoryCache Create memory cache holding at most size keys
+func NewMemoryCache(size int) *MemoryCache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	return &MemoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get Find live entry of key and mark it used, must be called with mu locked
+func (m *MemoryCache) get(key string) (*memoryEntry, bool) {
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.order.Remove(elem)
+		delete(m.entries, key)
+		return nil, false
+	}
+	m.order.MoveToFront(elem)
+	return entry, true
+}
+
+// set Save data under key, must be called with mu locked
+func (m *MemoryCache) set(key string, data []byte, expires time.Time) {
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*memoryEntry)
+		entry.data = data
+		entry.expires = expires
+		m.order.MoveToFront(elem)
+		return
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, data: data, expires: expires})
+	for m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+func (m *MemoryCache) Get(ctx context.Context, key string, v interface{}) error {
+	m.mu.Lock()
+	entry, ok := m.get(key)
+	var data []byte
+	if ok {
+		data = entry.data
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return ErrCacheMiss
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (m *MemoryCache) Set(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, data, expires)
+	return nil
+}
+
+func (m *MemoryCache) SetNX(ctx context.Context, key string, v interface{}, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.get(key); ok {
+		return false, nil
+	}
+	m.set(key, data, expires)
+	return true, nil
+}
+
+func (m *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if elem, ok := m.entries[key]; ok {
+			m.order.Remove(elem)
+			delete(m.entries, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCache) DeleteValue(ctx context.Context, key string, v interface{}) (bool, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.get(key)
+	if !ok || !bytes.Equal(entry.data, data) {
+		return false, nil
+	}
+	m.order.Remove(m.entries[key])
+	delete(m.entries, key)
+	return true, nil
+}
+
+func (m *MemoryCache) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var value int64
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	if entry, ok := m.get(key); ok {
+		v, err := strconv.ParseInt(string(entry.data), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		value = v
+		if !entry.expires.IsZero() {
+			expires = entry.expires
+		}
+	}
+	value += n
+	m.set(key, []byte(strconv.FormatInt(value, 10)), expires)
+	return value, nil
+}
+
+func (m *MemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryCache) Close() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(10)
+
+	if err := cache.Set(ctx, "short", 1, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(ctx, "forever", 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var v int
+	if err := cache.Get(ctx, "short", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v before expiry", v, err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := cache.Get(ctx, "short", &v); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("got %v after expiry, want ErrCacheMiss", err)
+	}
+	if err := cache.Get(ctx, "forever", &v); err != nil || v != 2 {
+		t.Errorf("got %d, %v for key without ttl", v, err)
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(2)
+
+	cache.Set(ctx, "a", 1, 0)
+	cache.Set(ctx, "b", 2, 0)
+	// Reading a makes b the least recently used
+	var v int
+	if err := cache.Get(ctx, "a", &v); err != nil {
+		t.Fatal(err)
+	}
+	cache.Set(ctx, "c", 3, 0)
+
+	if err := cache.Get(ctx, "b", &v); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("got %v for least recently used key, want ErrCacheMiss", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if err := cache.Get(ctx, key, &v); err != nil {
+			t.Errorf("got %v for %v, want it kept", err, key)
+		}
+	}
+}
+
+func TestMemoryCacheSetNX(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(10)
+
+	ok, err := cache.SetNX(ctx, "lock", "first", 20*time.Millisecond)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v for new key", ok, err)
+	}
+	if ok, _ = cache.SetNX(ctx, "lock", "second", 0); ok {
+		t.Error("existing key was overwritten")
+	}
+	var v string
+	cache.Get(ctx, "lock", &v)
+	if v != "first" {
+		t.Errorf("got %q, want first", v)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if ok, _ = cache.SetNX(ctx, "lock", "third", 0); !ok {
+		t.Error("expired key was not replaced")
+	}
+}
+
+func TestMemoryCacheDeleteValue(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(10)
+	cache.Set(ctx, "lock", "other", 0)
+
+	if ok, err := cache.DeleteValue(ctx, "lock", "mine"); err != nil || ok {
+		t.Fatalf("got %v, %v for key held by another value", ok, err)
+	}
+	var v string
+	if err := cache.Get(ctx, "lock", &v); err != nil {
+		t.Fatalf("key held by another value was deleted: %v", err)
+	}
+	if ok, err := cache.DeleteValue(ctx, "lock", "other"); err != nil || !ok {
+		t.Fatalf("got %v, %v for key holding the value", ok, err)
+	}
+	if err := cache.Get(ctx, "lock", &v); err != ErrCacheMiss {
+		t.Errorf("got %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestMemoryCacheIncr(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache(10)
+
+	for i, want := range []int64{1, 3, 6} {
+		got, err := cache.Incr(ctx, "counter", int64(i+1), 20*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	}
+
+	// The ttl is set when the counter is created and not extended by later increments
+	time.Sleep(40 * time.Millisecond)
+	got, err := cache.Incr(ctx, "counter", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("got %d after expiry, want 1", got)
+	}
+
+	var v int64
+	if err = cache.Get(ctx, "counter", &v); err != nil || v != 1 {
+		t.Errorf("got %d, %v reading counter", v, err)
+	}
+
+	// A counter left without ttl is given one
+	cache.Set(ctx, "kept", 5, 0)
+	if _, err = cache.Incr(ctx, "kept", 1, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err = cache.Get(ctx, "kept", &v); err != ErrCacheMiss {
+		t.Errorf("got %v for counter given ttl, want ErrCacheMiss", err)
+	}
+}
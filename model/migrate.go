@@ -0,0 +1,504 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"gorm.io/gorm"
+)
+
+// Migration A versioned change of the schema, Down reverts Up
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// MigrationStatus Whether a migration has been applied
+type MigrationStatus struct {
+	Version   uint       `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// SchemaMigration Applied migration recorded in database
+type SchemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// CreatedTable Table created by migration 1, tables made by hand before are not recorded
+type CreatedTable struct {
+	Name string `gorm:"primaryKey;size:64"`
+}
+
+func (CreatedTable) TableName() string {
+	return "schema_created_tables"
+}
+
+// schemaTable Table as a migration sees it. Models are declared by each migration,
+// so later changes of the models in database.go do not change what a migration does.
+type schemaTable struct {
+	name  string
+	model interface{}
+}
+
+func (t schemaTable) migrator(tx *gorm.DB) gorm.Migrator {
+	return tx.Table(t.name).Migrator()
+}
+
+// schemaIndex Index declared in the gorm tags of the model of a table
+type schemaIndex struct {
+	table schemaTable
+	name  string
+}
+
+// v1Tables Tables before forums, indexes and final banners
+func v1Tables() []schemaTable {
+	type User struct {
+		Id       uint
+		Rank     uint
+		Name     string `gorm:"size:128"`
+		Nickname string `gorm:"size:128"`
+		Link     string `gorm:"size:255"`
+		Level    uint
+		Exp      uint
+		Member   bool
+	}
+	type UserSnapshot struct {
+		Id     uint
+		Forum  string `gorm:"size:32"`
+		Name   string `gorm:"size:128"`
+		Date   time.Time
+		Rank   uint
+		Level  uint
+		Exp    uint
+		Member bool
+	}
+	type Anniversary struct {
+		Id          uint
+		Date        string `gorm:"size:16"`
+		Event       string
+		Adj         string
+		Description string
+	}
+	type Event struct {
+		Id    uint
+		Date  time.Time
+		Event string
+	}
+	type Post struct {
+		Id        uint
+		Date      time.Time
+		Total     uint
+		Followers uint
+		Members   uint
+		Vip       uint
+		Signin    uint
+	}
+	type History struct {
+		Id           uint
+		Date         time.Time
+		Distribution string
+	}
+	type Divider struct {
+		Id    uint
+		Level uint
+		Rank  uint
+	}
+	type UpIncome struct {
+		Id     uint
+		Name   string `gorm:"size:128"`
+		Date   time.Time
+		Income uint
+		Max    uint
+		Short  string
+	}
+	return []schemaTable{
+		{"user", &User{}}, {"user_snapshot", &UserSnapshot{}}, {"anniversary", &Anniversary{}}, {"event", &Event{}},
+		{"post", &Post{}}, {"history", &History{}}, {"divider", &Divider{}}, {"income", &UpIncome{}},
+	}
+}
+
+// v2ForumTables Tables given a forum column, rows saved before belong to config.DefaultForum
+func v2ForumTables() []schemaTable {
+	type Forum struct {
+		Forum string `gorm:"size:32;not null;default:genshin"`
+	}
+	return []schemaTable{{"user", &Forum{}}, {"post", &Forum{}}, {"history", &Forum{}}, {"divider", &Forum{}}}
+}
+
+// v3Indexes Unique keys of users and dividers and date indexes, with the text columns they cover
+func v3Indexes() ([]schemaIndex, []schemaTable) {
+	type User struct {
+		Forum string `gorm:"size:32;not null;default:genshin;uniqueIndex:idx_user_forum_name;uniqueIndex:idx_user_forum_link"`
+		Name  string `gorm:"size:128;uniqueIndex:idx_user_forum_name"`
+		Link  string `gorm:"size:255;uniqueIndex:idx_user_forum_link"`
+	}
+	type UserSnapshot struct {
+		Forum string    `gorm:"size:32;index:idx_user_snapshot_forum_name_date"`
+		Name  string    `gorm:"size:128;index:idx_user_snapshot_forum_name_date"`
+		Date  time.Time `gorm:"index:idx_user_snapshot_forum_name_date"`
+	}
+	type Anniversary struct {
+		Date string `gorm:"size:16;index:idx_anniversary_date"`
+	}
+	type Event struct {
+		Date time.Time `gorm:"index:idx_event_date"`
+	}
+	type Post struct {
+		Forum string    `gorm:"size:32;not null;default:genshin;index:idx_post_forum_date"`
+		Date  time.Time `gorm:"index:idx_post_forum_date"`
+	}
+	type History struct {
+		Forum string    `gorm:"size:32;not null;default:genshin;index:idx_history_forum_date"`
+		Date  time.Time `gorm:"index:idx_history_forum_date"`
+	}
+	type Divider struct {
+		Forum string `gorm:"size:32;not null;default:genshin;uniqueIndex:idx_divider_forum_level"`
+		Level uint   `gorm:"uniqueIndex:idx_divider_forum_level"`
+	}
+	type UpIncome struct {
+		Date time.Time `gorm:"index:idx_income_date"`
+	}
+
+	user := schemaTable{"user", &User{}}
+	snapshot := schemaTable{"user_snapshot", &UserSnapshot{}}
+	anniversary := schemaTable{"anniversary", &Anniversary{}}
+	post := schemaTable{"post", &Post{}}
+	history := schemaTable{"history", &History{}}
+	divider := schemaTable{"divider", &Divider{}}
+	indexes := []schemaIndex{
+		{user, "idx_user_forum_name"},
+		{user, "idx_user_forum_link"},
+		{snapshot, "idx_user_snapshot_forum_name_date"},
+		{anniversary, "idx_anniversary_date"},
+		{schemaTable{"event", &Event{}}, "idx_event_date"},
+		{post, "idx_post_forum_date"},
+		{history, "idx_history_forum_date"},
+		{divider, "idx_divider_forum_level"},
+		{schemaTable{"income", &UpIncome{}}, "idx_income_date"},
+	}
+	// Text columns must have a length before being indexed in MySQL, all fields of these models are text
+	return indexes, []schemaTable{user, snapshot, anniversary, post, history, divider}
+}
+
+// v4APIKey Keys signing requests
+func v4APIKey() schemaTable {
+	type APIKey struct {
+		Id        uint
+		KeyId     string `gorm:"size:32;uniqueIndex:idx_api_key_key_id"`
+		Secret    string `gorm:"size:64"`
+		Name      string `gorm:"size:128"`
+		Scopes    string `gorm:"size:64"`
+		CreatedAt time.Time
+		RevokedAt *time.Time
+	}
+	return schemaTable{"api_key", &APIKey{}}
+}
+
+// v5AuditLog Changes made by admins
+func v5AuditLog() schemaTable {
+	type AuditLog struct {
+		Id        uint
+		Actor     string    `gorm:"size:160"`
+		Action    string    `gorm:"size:16"`
+		Entity    string    `gorm:"size:32;index:idx_audit_log_entity"`
+		EntityId  uint      `gorm:"index:idx_audit_log_entity"`
+		Before    string    `gorm:"type:text"`
+		After     string    `gorm:"type:text"`
+		CreatedAt time.Time `gorm:"index:idx_audit_log_created_at"`
+	}
+	return schemaTable{"audit_log", &AuditLog{}}
+}
+
+// v6Final Banners whose income is no longer refreshed
+func v6Final() schemaTable {
+	type UpIncome struct {
+		Final bool
+	}
+	return schemaTable{"income", &UpIncome{}}
+}
+
+// v7JobRun Last successful runs of scheduled jobs
+func v7JobRun() schemaTable {
+	type JobRun struct {
+		Name    string `gorm:"primaryKey;size:32"`
+		LastRun time.Time
+	}
+	return schemaTable{"job_run", &JobRun{}}
+}
+
+// createTable Create table of a migration unless it was made before
+func createTable(tx *gorm.DB, t schemaTable) error {
+	if t.migrator(tx).HasTable(t.model) {
+		return nil
+	}
+	return t.migrator(tx).CreateTable(t.model)
+}
+
+// Migrations All migrations sorted by version, new migrations are appended
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create tables",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasTable(&CreatedTable{}) {
+				if err := tx.Migrator().CreateTable(&CreatedTable{}); err != nil {
+					return err
+				}
+			}
+			// Tables created by hand before migrations existed are kept
+			for _, t := range v1Tables() {
+				if t.migrator(tx).HasTable(t.model) {
+					continue
+				}
+				if err := t.migrator(tx).CreateTable(t.model); err != nil {
+					return err
+				}
+				if err := tx.Create(&CreatedTable{Name: t.name}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			created, err := createdTables(tx)
+			if err != nil {
+				return err
+			}
+			for name := range created {
+				if err = tx.Migrator().DropTable(name); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&CreatedTable{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add forum to tables",
+		Up: func(tx *gorm.DB) error {
+			for _, t := range v2ForumTables() {
+				if !t.migrator(tx).HasColumn(t.model, "Forum") {
+					if err := t.migrator(tx).AddColumn(t.model, "Forum"); err != nil {
+						return err
+					}
+				}
+				// Rows saved before forum support belong to the default forum
+				err := tx.Table(t.name).Where("forum IS NULL OR forum = ?", "").
+					Update("forum", config.DefaultForum.Key).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			for _, t := range v2ForumTables() {
+				if !t.migrator(tx).HasColumn(t.model, "Forum") {
+					continue
+				}
+				if err := t.migrator(tx).DropColumn(t.model, "Forum"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add user keys and date indexes",
+		Up: func(tx *gorm.DB) error {
+			indexes, indexed := v3Indexes()
+			if tx.Dialector.Name() == "mysql" {
+				for _, t := range indexed {
+					stmt := &gorm.Statement{DB: tx}
+					if err := stmt.Parse(t.model); err != nil {
+						return err
+					}
+					for _, field := range stmt.Schema.Fields {
+						if err := t.migrator(tx).AlterColumn(t.model, field.Name); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			for _, idx := range indexes {
+				if idx.table.migrator(tx).HasIndex(idx.table.model, idx.name) {
+					continue
+				}
+				if err := idx.table.migrator(tx).CreateIndex(idx.table.model, idx.name); err != nil {
+					return fmt.Errorf("create index %v: %w", idx.name, err)
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			indexes, _ := v3Indexes()
+			for _, idx := range indexes {
+				if !idx.table.migrator(tx).HasIndex(idx.table.model, idx.name) {
+					continue
+				}
+				if err := idx.table.migrator(tx).DropIndex(idx.table.model, idx.name); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create api keys",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, v4APIKey())
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(v4APIKey().name)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create audit log",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, v5AuditLog())
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(v5AuditLog().name)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add final to income",
+		Up: func(tx *gorm.DB) error {
+			t := v6Final()
+			if t.migrator(tx).HasColumn(t.model, "Final") {
+				return nil
+			}
+			return t.migrator(tx).AddColumn(t.model, "Final")
+		},
+		Down: func(tx *gorm.DB) error {
+			t := v6Final()
+			return t.migrator(tx).DropColumn(t.model, "Final")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create job runs",
+		Up: func(tx *gorm.DB) error {
+			return createTable(tx, v7JobRun())
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(v7JobRun().name)
+		},
+	},
+}
+
+// createdTables Names of tables created by migration 1
+func createdTables(tx *gorm.DB) (map[string]bool, error) {
+	var records []CreatedTable
+	if err := tx.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	created := make(map[string]bool, len(records))
+	for _, record := range records {
+		created[record.Name] = true
+	}
+	return created, nil
+}
+
+func (s *GormStore) appliedMigrations() (map[uint]SchemaMigration, error) {
+	if err := s.DB.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var records []SchemaMigration
+	if err := s.DB.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint]SchemaMigration)
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// MigrationStatus Get all migrations and when they were applied
+func (s *GormStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(Migrations))
+	for _, m := range Migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if record, ok := applied[m.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// MigrateUp Apply all pending migrations in order, the migrations applied are returned
+func (s *GormStore) MigrateUp() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0)
+	for _, m := range Migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		// MySQL commits DDL implicitly, so a failed migration may be left partly applied
+		now := time.Now()
+		err = s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: now}).Error
+		})
+		if err != nil {
+			return result, fmt.Errorf("migration %d %v: %w", m.Version, m.Name, err)
+		}
+		result = append(result, MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: &now})
+	}
+	return result, nil
+}
+
+// MigrateDown Revert the latest applied migration, ErrNotFound is returned when none is applied
+func (s *GormStore) MigrateDown() (MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	for i := len(Migrations) - 1; i >= 0; i-- {
+		m := Migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err = s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return MigrationStatus{}, fmt.Errorf("migration %d %v: %w", m.Version, m.Name, err)
+		}
+		return MigrationStatus{Version: m.Version, Name: m.Name}, nil
+	}
+	return MigrationStatus{}, ErrNotFound
+}
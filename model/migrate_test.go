@@ -0,0 +1,97 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/DRJ31/tiebarankgo/config"
+)
+
+func TestMigrateHandMadeTables(t *testing.T) {
+	store, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Table made by hand before migrations and forums existed
+	err = store.DB.Exec("CREATE TABLE `user` (id integer PRIMARY KEY, `rank` integer, name varchar(128), " +
+		"nickname varchar(128), link varchar(255), level integer, exp integer, member numeric)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.DB.Exec("INSERT INTO user (name, link) VALUES ('alice', '/alice')").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	if err = store.DB.First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Forum != config.DefaultForum.Key {
+		t.Errorf("got forum %q, want %q", user.Forum, config.DefaultForum.Key)
+	}
+
+	for range Migrations {
+		if _, err = store.MigrateDown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	migrator := store.DB.Migrator()
+	if !migrator.HasTable(&User{}) {
+		t.Fatal("hand made table was dropped")
+	}
+	if migrator.HasTable(&Post{}) || migrator.HasTable(&CreatedTable{}) {
+		t.Error("tables created by migration were kept")
+	}
+	var count int64
+	store.DB.Table("user").Count(&count)
+	if count != 1 {
+		t.Errorf("got %d rows in hand made table, want 1", count)
+	}
+}
+
+func TestMigrateDownToForum(t *testing.T) {
+	// Running up to 2 on a fresh database must leave the same tables as rolling back to 2
+	fresh, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	for _, m := range Migrations[:2] {
+		if err = m.Up(fresh.DB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkForumSchema(t, fresh)
+
+	store := newTestStore(t)
+	for i := len(Migrations); i > 2; i-- {
+		if _, err = store.MigrateDown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkForumSchema(t, store)
+}
+
+// checkForumSchema Check that tables are as migration 2 left them
+func checkForumSchema(t *testing.T, store *GormStore) {
+	t.Helper()
+	migrator := store.DB.Migrator()
+	for _, name := range []string{"idx_user_forum_name", "idx_user_forum_link"} {
+		if migrator.HasIndex(&User{}, name) {
+			t.Errorf("index %v of migration 3 was kept", name)
+		}
+	}
+	if migrator.HasIndex(&Divider{}, "idx_divider_forum_level") {
+		t.Error("index idx_divider_forum_level of migration 3 was kept")
+	}
+	if !migrator.HasColumn(&User{}, "Forum") {
+		t.Error("forum column of migration 2 was dropped")
+	}
+	if migrator.HasTable(&APIKey{}) || migrator.HasTable(&JobRun{}) {
+		t.Error("tables of later migrations were kept")
+	}
+}
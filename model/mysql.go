@@ -0,0 +1,30 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// OpenMySQL Open MySQL store with a connection pool shared by all requests
+func OpenMySQL(cf config.Config) (*GormStore, error) {
+	formatStr := "%v:%v@tcp(%v:%v)/%v?charset=utf8mb4&parseTime=True&loc=Local"
+	dsn := fmt.Sprintf(formatStr, cf.Username, cf.Password, cf.DBHost, cf.DBPort, cf.Database)
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(20)
+	sqlDB.SetMaxIdleConns(10)
+	sqlDB.SetConnMaxLifetime(time.Hour)
+
+	return &GormStore{DB: db}, nil
+}
@@ -1,16 +1,94 @@
 package model
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
+
 	"github.com/DRJ31/tiebarankgo/config"
 	"github.com/go-redis/redis/v8"
 )
 
-func InitRedis() *redis.Client {
-	cf := config.GetConfig()
-	return redis.NewClient(&redis.Options{
+// RedisCache Cache stored in redis, shared by all instances of the server
+type RedisCache struct {
+	Client *redis.Client
+}
+
+// NewRedisCache Create redis cache with a client shared by all requests
+func NewRedisCache(cf config.Config) *RedisCache {
+	return &RedisCache{Client: redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%v:%v", cf.RedisHost, cf.RedisPort),
 		Password: "",
 		DB:       0,
-	})
+	})}
+}
+
+func (r *RedisCache) Get(ctx context.Context, key string, v interface{}) error {
+	data, err := r.Client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return ErrCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (r *RedisCache) Set(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Client.Set(ctx, key, data, ttl).Err()
+}
+
+func (r *RedisCache) SetNX(ctx context.Context, key string, v interface{}, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	return r.Client.SetNX(ctx, key, data, ttl).Result()
+}
+
+func (r *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	return r.Client.Del(ctx, keys...).Err()
+}
+
+// deleteValueScript Delete KEYS[1] if it holds ARGV[1], checked and deleted atomically in redis
+var deleteValueScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (r *RedisCache) DeleteValue(ctx context.Context, key string, v interface{}) (bool, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := deleteValueScript.Run(ctx, r.Client, []string{key}, data).Int()
+	return deleted == 1, err
+}
+
+// incrScript Add ARGV[1] to KEYS[1] and give it a ttl of ARGV[2] milliseconds if it has none,
+// run as one script so a key is never left without ttl
+var incrScript = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value`)
+
+func (r *RedisCache) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	return incrScript.Run(ctx, r.Client, []string{key}, n, ttl.Milliseconds()).Int64()
+}
+
+func (r *RedisCache) Ping(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
+}
+
+func (r *RedisCache) Close() error {
+	return r.Client.Close()
 }
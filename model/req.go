@@ -4,25 +4,59 @@ import "time"
 
 type UserLink struct {
 	Token string `json:"token" xml:"token"`
+	Forum string `json:"forum" xml:"forum"`
 	Link  string `json:"link" xml:"link"`
 }
 
 type RankInfo struct {
 	Token string `json:"token" xml:"token"`
+	Forum string `json:"forum" xml:"forum"`
 	Rank  uint   `json:"rank" xml:"rank"`
 	Level uint   `json:"level" xml:"level"`
 }
 
 type PostInfo struct {
 	Token     string `json:"token" xml:"token"`
+	Forum     string `json:"forum" xml:"forum"`
 	Followers uint   `json:"followers" xml:"followers"`
 	Total     uint   `json:"total" xml:"total"`
 	Signin    uint   `json:"signin" xml:"signin"`
 }
 
-type SendUsers struct {
-	Token string `json:"token"`
-	Users []User `json:"users"`
+type CrawlInfo struct {
+	Forum   string `json:"forum" xml:"forum"`
+	Restart bool   `json:"restart" xml:"restart"`
+}
+
+// EventInfo Event written by admins, Date is formatted as C.DATEFMT
+type EventInfo struct {
+	Date  string `json:"date" xml:"date"`
+	Event string `json:"event" xml:"event"`
+}
+
+// AnniversaryInfo Anniversary written by admins, Date of the first occurrence is formatted as C.DATEFMT
+type AnniversaryInfo struct {
+	Date        string `json:"date" xml:"date"`
+	Event       string `json:"event" xml:"event"`
+	Adj         string `json:"adj" xml:"adj"`
+	Description string `json:"description" xml:"description"`
+}
+
+// BannerInfo Banner written by admins, Date is formatted as C.DATEFMT.
+// Income and Max are refreshed from chandashi unless Final is set.
+type BannerInfo struct {
+	Name   string `json:"name" xml:"name"`
+	Short  string `json:"short" xml:"short"`
+	Date   string `json:"date" xml:"date"`
+	Income uint   `json:"income" xml:"income"`
+	Max    uint   `json:"max" xml:"max"`
+	Final  bool   `json:"final" xml:"final"`
+}
+
+// ImportInfo Events or anniversaries imported at once
+type ImportInfo struct {
+	Events        []EventInfo       `json:"events" xml:"events"`
+	Anniversaries []AnniversaryInfo `json:"anniversaries" xml:"anniversaries"`
 }
 
 type IncomeData struct {
@@ -48,3 +82,17 @@ type IncomeData struct {
 		} `json:"versions"`
 	} `json:"data"`
 }
+
+// Series Date and income pairs of series i, malformed pairs are skipped
+func (d IncomeData) Series(i int) [][]uint {
+	if i >= len(d.Data.Points) {
+		return nil
+	}
+	points := make([][]uint, 0, len(d.Data.Points[i].Data))
+	for _, data := range d.Data.Points[i].Data {
+		if len(data) >= 2 {
+			points = append(points, data)
+		}
+	}
+	return points
+}
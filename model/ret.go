@@ -10,6 +10,16 @@ type TiebaUser struct {
 	Nickname string `json:"nickname"`
 }
 
+// UserDaily Change of a user's ranking in a day
+type UserDaily struct {
+	Date       string `json:"date"`
+	Rank       uint   `json:"rank"`
+	Level      uint   `json:"level"`
+	Exp        uint   `json:"exp"`
+	ExpGain    int    `json:"exp_gain"`
+	RankChange int    `json:"rank_change"` // Positive when the user moved up
+}
+
 type UserAvatar struct {
 	Avatar   string `json:"avatar"`
 	Nickname string `json:"nickname"`
@@ -31,6 +41,13 @@ type DistRet struct {
 	Delta int  `json:"delta"`
 }
 
+// PageLevel Levels of users found in a furank page
+type PageLevel struct {
+	Found bool `json:"found"` // Whether there is a user below the level in the page
+	Rank  uint `json:"rank"`  // Rank before the first user below the level
+	Last  uint `json:"last"`  // Rank of the last user in the page, 0 for an empty page
+}
+
 type DistInfo struct {
 	Level uint `json:"level"`
 	Rank  uint `json:"rank"`
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// OpenSQLite Open SQLite store in file path, ":memory:" keeps the database in memory
+func OpenSQLite(path string) (*GormStore, error) {
+	if path == "" {
+		path = "tieba.db"
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite does not support concurrent writers
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	return &GormStore{DB: db}, nil
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"gorm.io/gorm"
+)
+
+// ErrNotFound Record not found in a store
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// UserStore Storage of forum members and their ranking history
+type UserStore interface {
+	GetUser(forum, name string) (User, error)
+	GetUserByLink(forum, link string) (User, error)
+	FindUsers(forum, keyword string) ([]User, error)
+	TopUser(forum string) (User, error) // User with the highest level
+	CountVip(forum string) (int64, error)
+	// SaveUsers Create or renew users crawled from a furank page, recording snapshots of users whose ranking changed
+	SaveUsers(forum string, users []TiebaUser, at time.Time) error
+	UpdateNickname(user User, nickname string) error
+	GetUserSnapshots(forum, name string) ([]UserSnapshot, error)
+	// EachUser Call fn on users of forum sorted by rank, users are read a page at a time
+	EachUser(forum string, fn func(user User) error) error
+}
+
+// ErrDuplicate Record written duplicates an existing one
+var ErrDuplicate = errors.New("duplicate record")
+
+// EventStore Storage of events and anniversaries.
+// Changes are made on behalf of actor and recorded in the audit log, ErrDuplicate is returned
+// for an event on the same day or an anniversary on the same date with the same text.
+type EventStore interface {
+	GetEvents() ([]Event, error)
+	GetEventsOn(day time.Time) ([]Event, error)
+	GetAnniversaries() ([]Anniversary, error)
+	// CreateEvents Create events in one transaction, none of them is created if any fails
+	CreateEvents(events []Event, actor string) error
+	UpdateEvent(event *Event, actor string) error
+	DeleteEvent(id uint, actor string) error
+	// CreateAnniversaries Create anniversaries in one transaction, none of them is created if any fails
+	CreateAnniversaries(anniversaries []Anniversary, actor string) error
+	UpdateAnniversary(anniversary *Anniversary, actor string) error
+	DeleteAnniversary(id uint, actor string) error
+}
+
+// JobStore Last successful runs of scheduled jobs
+type JobStore interface {
+	// GetJobRun Get scheduled time of the last successful run of job name
+	GetJobRun(name string) (time.Time, error)
+	SaveJobRun(name string, at time.Time) error
+}
+
+// AuditStore Log of changes made by admins
+type AuditStore interface {
+	// GetAuditLogs Get latest changes of entity, all entities when it is empty
+	GetAuditLogs(entity string, limit int) ([]AuditLog, error)
+}
+
+// PostStore Storage of daily post and distribution snapshots
+type PostStore interface {
+	GetPosts(forum string) ([]Post, error) // Sorted by date desc
+	GetPost(forum string, day time.Time) (Post, error)
+	// CreatePost Save post of a day with its history in one transaction,
+	// ErrDuplicate is returned when the forum already has a post on the day
+	CreatePost(post *Post, history *History) error
+	GetHistory(forum string, day time.Time) (History, error)
+	// GetPostsBetween Get posts from day from to day to sorted by date, zero days are not limited
+	GetPostsBetween(forum string, from, to time.Time) ([]Post, error)
+	// GetHistories Get histories from day from to day to sorted by date, zero days are not limited
+	GetHistories(forum string, from, to time.Time) ([]History, error)
+}
+
+// IncomeStore Storage of banners and their income.
+// Changes made by admins are recorded in the audit log, ErrDuplicate is returned
+// for a banner with the same name starting on the same day.
+type IncomeStore interface {
+	GetIncome(id uint) (UpIncome, error)
+	GetIncomes() ([]UpIncome, error)
+	GetIncomesOn(day time.Time) ([]UpIncome, error)
+	GetIncomesBefore(day time.Time) ([]UpIncome, error)
+	// SaveIncomes Save refreshed Income and Max of banners, banners marked final are kept
+	SaveIncomes(incomes []UpIncome) error
+	CreateIncome(income *UpIncome, actor string) error
+	UpdateIncome(income *UpIncome, actor string) error
+	DeleteIncome(id uint, actor string) error
+}
+
+// DividerStore Storage of last ranks of levels
+type DividerStore interface {
+	GetDividers(forum string) ([]Divider, error) // Sorted by level desc
+	CreateDivider(divider *Divider) error
+	UpdateDivider(forum string, level, rank uint) error
+}
+
+// Migrator Versioned migrations of the schema of a store
+type Migrator interface {
+	MigrationStatus() ([]MigrationStatus, error)
+	MigrateUp() ([]MigrationStatus, error)
+	MigrateDown() (MigrationStatus, error)
+}
+
+// APIKeyStore Storage of API keys issued to clients
+type APIKeyStore interface {
+	GetAPIKey(keyId string) (APIKey, error)
+	ListAPIKeys() ([]APIKey, error)
+	CreateAPIKey(key *APIKey) error
+	RevokeAPIKey(keyId string, at time.Time) error
+}
+
+// Store All storages used by the server
+type Store interface {
+	UserStore
+	EventStore
+	PostStore
+	IncomeStore
+	DividerStore
+	APIKeyStore
+	AuditStore
+	JobStore
+	Migrator
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Open Open store selected by cf.Storage, MySQL is used by default
+func Open(cf config.Config) (Store, error) {
+	var store *GormStore
+	var err error
+	switch cf.Storage {
+	case "", "mysql":
+		store, err = OpenMySQL(cf)
+	case "sqlite":
+		store, err = OpenSQLite(cf.SQLitePath)
+	default:
+		err = fmt.Errorf("unknown storage %v", cf.Storage)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
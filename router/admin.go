@@ -0,0 +1,65 @@
+package router
+
+import (
+	"errors"
+
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/DRJ31/tiebarankgo/task"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetCrawl Get progress of the roster crawl of a forum
+func GetCrawl(c *fiber.Ctx) error {
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
+	}
+
+	return c.JSON(fiber.Map{"crawl": task.GetRosterStatus(forum)})
+}
+
+// StartCrawl Start or resume the roster crawl of a forum
+func StartCrawl(c *fiber.Ctx) error {
+	var info model.CrawlInfo
+	if err := c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+
+	forum, ok := getForum(info.Forum)
+	if !ok {
+		return ErrBadForum
+	}
+
+	status, err := task.StartRoster(forum, info.Restart)
+	if errors.Is(err, task.ErrRosterRunning) {
+		return conflict(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"crawl": status})
+}
+
+// StopCrawl Stop the roster crawl of a forum
+func StopCrawl(c *fiber.Ctx) error {
+	var info model.CrawlInfo
+	if err := c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+
+	forum, ok := getForum(info.Forum)
+	if !ok {
+		return ErrBadForum
+	}
+
+	status, err := task.StopRoster(forum)
+	if errors.Is(err, task.ErrRosterNotRunning) {
+		return conflict(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"crawl": status})
+}
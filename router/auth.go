@@ -0,0 +1,104 @@
+package router
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/auth"
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/DRJ31/tiebarankgo/secrets"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultSignatureWindow Time a signed request is valid for when it is not configured
+const DefaultSignatureWindow = 5 * time.Minute
+
+// keyLocal Key of the API key of a signed request in locals of fiber
+const keyLocal = "api_key"
+
+// Auth Require requests signed by an API key with scope, rate limits of the route apply to the IP until the key is verified.
+// Unsigned reads are left to the legacy tokens checked by handlers unless they are disabled,
+// admin and ingest requests always need an API key.
+func Auth(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Clients are limited by IP before verifying, so requests with junk keys are limited too
+		if err := checkRateLimit(c); err != nil {
+			return err
+		}
+
+		keyId := c.Get(auth.HeaderKey)
+		if keyId == "" {
+			if scope != auth.ScopeRead || conf.Get().DisableLegacyToken {
+				return unauthorized("Missing API Key")
+			}
+			return c.Next()
+		}
+
+		key, err := verifyRequest(c, keyId)
+		if err != nil {
+			return err
+		}
+		if !auth.HasScope(key.Scopes, scope) {
+			return ErrForbidden
+		}
+		// Signed requests count against their key only, clients sharing an IP are not limited together
+		refundRateLimit(c, clientId(c))
+		c.Locals(keyLocal, key)
+		if err = checkRateLimit(c); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// verifyRequest Check timestamp and signature of a request signed by key keyId, signatures are accepted once
+func verifyRequest(c *fiber.Ctx, keyId string) (model.APIKey, error) {
+	window := time.Duration(conf.Get().SignatureWindow) * time.Second
+	if window <= 0 {
+		window = DefaultSignatureWindow
+	}
+
+	timestamp := c.Get(auth.HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return model.APIKey{}, unauthorized("Invalid Timestamp")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > window || age < -window {
+		return model.APIKey{}, unauthorized("Expired Request")
+	}
+
+	key, err := store.GetAPIKey(keyId)
+	if errors.Is(err, model.ErrNotFound) || (err == nil && key.RevokedAt != nil) {
+		return model.APIKey{}, unauthorized("Invalid API Key")
+	}
+	if err != nil {
+		return model.APIKey{}, err
+	}
+
+	signature := c.Get(auth.HeaderSignature)
+	path := string(c.Request().URI().PathOriginal())
+	query := string(c.Request().URI().QueryString())
+	if !auth.Verify(key.Secret, signature, c.Method(), path, query, timestamp, c.Body()) {
+		return model.APIKey{}, unauthorized("Invalid Signature")
+	}
+
+	// Signatures are kept until their timestamps leave the window
+	fresh, err := cache.SetNX(ctx, model.SignatureKey(keyId, signature), unix, 2*window)
+	if err != nil {
+		log.Println(err)
+	} else if !fresh {
+		return model.APIKey{}, unauthorized("Replayed Request")
+	}
+	return key, nil
+}
+
+// checkToken Check legacy token of value, requests signed by an API key have been checked by Auth
+func checkToken(c *fiber.Ctx, value, token string) bool {
+	if c.Locals(keyLocal) != nil {
+		return true
+	}
+	return !conf.Get().DisableLegacyToken && secrets.TokenCheck(C.SALT, value, token)
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DRJ31/tiebarankgo/auth"
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAuthLegacyToken(t *testing.T) {
+	newTestApp(t)
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Get("/read", Auth(auth.ScopeRead), ok)
+	app.Get("/ingest", Auth(auth.ScopeIngest), ok)
+	app.Get("/admin", Auth(auth.ScopeAdmin), ok)
+
+	// Unsigned requests fall back to legacy tokens only when reading
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/read", fiber.StatusOK},
+		{"/ingest", fiber.StatusUnauthorized},
+		{"/admin", fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		res, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("got status %d for unsigned %v, want %d", res.StatusCode, tt.path, tt.status)
+		}
+	}
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	_, s := newTestApp(t)
+	key := model.APIKey{KeyId: "client", Secret: "secret", Name: "client", Scopes: auth.ScopeRead}
+	if err := s.CreateAPIKey(&key); err != nil {
+		t.Fatal(err)
+	}
+	reset := func() {
+		Setup(config.NewLive(config.Config{
+			RateLimits: map[string]config.RateLimit{
+				"default":       {Requests: 2, Window: 60},
+				"POST /limited": {Requests: 1, Window: 60},
+			},
+		}), s, model.NewMemoryCache(100))
+	}
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Get("/limited", Auth(auth.ScopeRead), ok)
+	app.Post("/limited", Auth(auth.ScopeRead), ok)
+
+	sent := 0
+	send := func(method string, keyId string) int {
+		// Queries differ so signatures are not taken as replays
+		sent++
+		req := httptest.NewRequest(method, fmt.Sprintf("/limited?n=%d", sent), nil)
+		if keyId == key.KeyId {
+			auth.SignRequest(req, key.KeyId, key.Secret, nil)
+		} else if keyId != "" {
+			req.Header.Set(auth.HeaderKey, keyId)
+		}
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode
+	}
+
+	// Signed requests count against their key, not the IP they share with others
+	reset()
+	for i := 0; i < 2; i++ {
+		if status := send("GET", key.KeyId); status != fiber.StatusOK {
+			t.Fatalf("got status %d for signed request, want 200", status)
+		}
+	}
+	if status := send("GET", key.KeyId); status != fiber.StatusTooManyRequests {
+		t.Errorf("got status %d for signed request over the limit, want 429", status)
+	}
+	if status := send("GET", ""); status != fiber.StatusOK {
+		t.Errorf("got status %d for unsigned request from the same IP, want 200", status)
+	}
+
+	// Requests with unknown keys count against the IP before they are rejected
+	reset()
+	for i := 0; i < 2; i++ {
+		if status := send("GET", "junk"); status != fiber.StatusUnauthorized {
+			t.Fatalf("got status %d for junk key, want 401", status)
+		}
+	}
+	if status := send("GET", "junk"); status != fiber.StatusTooManyRequests {
+		t.Errorf("got status %d over the limit, want 429", status)
+	}
+
+	// Other methods of the path have their own bucket and limit
+	if status := send("POST", ""); status != fiber.StatusOK {
+		t.Errorf("got status %d for POST, want 200", status)
+	}
+	if status := send("POST", ""); status != fiber.StatusTooManyRequests {
+		t.Errorf("got status %d for POST over its limit, want 429", status)
+	}
+}
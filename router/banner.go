@@ -0,0 +1,180 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DRJ31/tiebarankgo/model"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrBannerFinal Figures of a banner marked final are not recomputed
+var ErrBannerFinal = &APIError{Status: fiber.StatusConflict, Code: CodeConflict, Message: "Banner Is Final"}
+
+func toBanner(info model.BannerInfo) (model.UpIncome, error) {
+	date, err := parseDate(info.Date)
+	if err != nil {
+		return model.UpIncome{}, errors.New("date does not match " + C.DATEFMT)
+	}
+	name := strings.TrimSpace(info.Name)
+	if name == "" {
+		return model.UpIncome{}, errors.New("name is empty")
+	}
+	if info.Max > info.Income {
+		return model.UpIncome{}, errors.New("max is greater than income")
+	}
+	return model.UpIncome{
+		Name:   name,
+		Short:  strings.TrimSpace(info.Short),
+		Date:   date,
+		Income: info.Income,
+		Max:    info.Max,
+		Final:  info.Final,
+	}, nil
+}
+
+// GetBanners Get all banners
+func GetBanners(c *fiber.Ctx) error {
+	if _, err := actor(c); err != nil {
+		return err
+	}
+
+	banners, err := store.GetIncomes()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"banners": banners})
+}
+
+// CreateBanner Create a banner, its income is refreshed by GetIncome unless it is final
+func CreateBanner(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.BannerInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	banner, err := toBanner(info)
+	if err != nil {
+		return invalid(fmt.Errorf("banner: %w", err))
+	}
+
+	if err = store.CreateIncome(&banner, who); err != nil {
+		return err
+	}
+
+	c.Status(fiber.StatusCreated)
+	return c.JSON(fiber.Map{"banner": banner})
+}
+
+// UpdateBanner Replace all fields of a banner
+func UpdateBanner(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.BannerInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	banner, err := toBanner(info)
+	if err != nil {
+		return invalid(fmt.Errorf("banner: %w", err))
+	}
+
+	banner.Id = id
+	if err = store.UpdateIncome(&banner, who); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"banner": banner})
+}
+
+// DeleteBanner Delete a banner
+func DeleteBanner(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	if err = store.DeleteIncome(id, who); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RefreshBanner Recompute income of a banner now, banners marked final are kept
+func RefreshBanner(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	banner, err := store.GetIncome(id)
+	if err != nil {
+		return err
+	}
+	if banner.Final {
+		return ErrBannerFinal
+	}
+
+	if err = computeIncome(&banner); err != nil {
+		return upstreamIncome(err)
+	}
+	if err = store.UpdateIncome(&banner, who); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"banner": banner})
+}
+
+// FinalizeBanner Mark figures of a banner final or not, final banners are no longer refreshed
+func FinalizeBanner(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	final := true
+	if value := c.Query("final"); value != "" {
+		if final, err = strconv.ParseBool(value); err != nil {
+			return badParameter("Final", err)
+		}
+	}
+
+	banner, err := store.GetIncome(id)
+	if err != nil {
+		return err
+	}
+	banner.Final = final
+	if err = store.UpdateIncome(&banner, who); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"banner": banner})
+}
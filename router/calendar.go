@@ -0,0 +1,157 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Categories of the calendar feed
+const (
+	CalendarEvents        = "events"
+	CalendarAnniversaries = "anniversaries"
+	CalendarBanners       = "banners"
+)
+
+var calendarCategories = []string{CalendarEvents, CalendarAnniversaries, CalendarBanners}
+
+// icsLineLength Maximum length of a content line in octets, longer lines are folded
+const icsLineLength = 75
+
+// calendar iCalendar (RFC 5545) document of all-day events
+type calendar struct {
+	b     strings.Builder
+	stamp string
+}
+
+func newCalendar(name string) *calendar {
+	cal := &calendar{stamp: time.Now().UTC().Format("20060102T150405Z")}
+	cal.line("BEGIN:VCALENDAR")
+	cal.line("VERSION:2.0")
+	cal.line("PRODID:-//tiebarankgo//calendar//EN")
+	cal.line("CALSCALE:GREGORIAN")
+	cal.line("METHOD:PUBLISH")
+	cal.line("X-WR-CALNAME:" + icsEscape(name))
+	return cal
+}
+
+// line Write a content line, folded into lines of at most icsLineLength octets without splitting characters
+func (cal *calendar) line(s string) {
+	limit := icsLineLength
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		cal.b.WriteString(s[:cut])
+		cal.b.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space, which counts against their length
+		limit = icsLineLength - 1
+	}
+	cal.b.WriteString(s)
+	cal.b.WriteString("\r\n")
+}
+
+// event Write an all-day event, yearly events recur every year on day
+func (cal *calendar) event(uid string, day time.Time, summary, description, category string, yearly bool) {
+	cal.line("BEGIN:VEVENT")
+	cal.line("UID:" + uid + "@tiebarank")
+	cal.line("DTSTAMP:" + cal.stamp)
+	cal.line("DTSTART;VALUE=DATE:" + day.Format(C.SHORT_DATE))
+	cal.line("DTEND;VALUE=DATE:" + day.AddDate(0, 0, 1).Format(C.SHORT_DATE))
+	if yearly {
+		cal.line("RRULE:FREQ=YEARLY")
+	}
+	cal.line("SUMMARY:" + icsEscape(summary))
+	if description != "" {
+		cal.line("DESCRIPTION:" + icsEscape(description))
+	}
+	cal.line("CATEGORIES:" + icsEscape(category))
+	cal.line("TRANSP:TRANSPARENT")
+	cal.line("END:VEVENT")
+}
+
+func (cal *calendar) String() string {
+	return cal.b.String() + "END:VCALENDAR\r\n"
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// icsEscape Escape text value of a property
+var icsEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace
+
+// parseCategories Get categories requested by a comma separated list, all categories when it is empty
+func parseCategories(value string) (map[string]bool, error) {
+	include := make(map[string]bool)
+	if value == "" {
+		for _, category := range calendarCategories {
+			include[category] = true
+		}
+		return include, nil
+	}
+	for _, category := range strings.Split(value, ",") {
+		category = strings.TrimSpace(category)
+		if !inArr(calendarCategories, category) {
+			return nil, fmt.Errorf("unknown category %q, expected %v", category, strings.Join(calendarCategories, ","))
+		}
+		include[category] = true
+	}
+	return include, nil
+}
+
+// GetCalendar Get events, anniversaries and banners as an iCalendar feed for calendar apps to subscribe,
+// categories are selected by query include, e.g. include=events,banners
+func GetCalendar(c *fiber.Ctx) error {
+	include, err := parseCategories(c.Query("include"))
+	if err != nil {
+		return invalid(err)
+	}
+
+	cal := newCalendar("Tieba Rank")
+
+	if include[CalendarEvents] {
+		events, err := store.GetEvents()
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			cal.event(fmt.Sprintf("event-%d", e.Id), e.Date, e.Event, "", "Event", false)
+		}
+	}
+
+	if include[CalendarAnniversaries] {
+		anniversaries, err := store.GetAnniversaries()
+		if err != nil {
+			return err
+		}
+		for _, a := range anniversaries {
+			day, err := parseDate(a.Date)
+			if err != nil {
+				log.Printf("Anniversary %d skipped in calendar: %v", a.Id, err)
+				continue
+			}
+			description := strings.TrimSpace(strings.Join([]string{a.Adj, a.Description}, "\n"))
+			cal.event(fmt.Sprintf("anniversary-%d", a.Id), day, a.Event, description, "Anniversary", true)
+		}
+	}
+
+	if include[CalendarBanners] {
+		banners, err := store.GetIncomes()
+		if err != nil {
+			return err
+		}
+		for _, b := range banners {
+			cal.event(fmt.Sprintf("banner-%d", b.Id), b.Date, b.Name+"池", b.Short, "Banner", false)
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	return c.SendString(cal.String())
+}
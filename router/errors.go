@@ -0,0 +1,128 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Codes of errors returned to clients
+const (
+	CodeBadToken     = "bad_token"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeBadParameter = "bad_parameter"
+	CodeNotFound     = "not_found"
+	CodeConflict     = "conflict"
+	CodeRateLimited  = "rate_limited"
+	CodeUpstream     = "upstream_" // Followed by the name of the upstream, e.g. upstream_tieba
+	CodeInternal     = "internal"
+)
+
+// APIError Error returned to clients as JSON with a code and HTTP status
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Err     error  `json:"-"` // Cause logged by the server, it is not sent to clients
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// ErrBadToken Token of the request does not match its parameters
+var ErrBadToken = &APIError{Status: fiber.StatusBadRequest, Code: CodeBadToken, Message: "Invalid Request"}
+
+// ErrForbidden API key of the request lacks the scope of the route
+var ErrForbidden = &APIError{Status: fiber.StatusForbidden, Code: CodeForbidden, Message: "Forbidden"}
+
+// unauthorized Request is not signed by a valid API key
+func unauthorized(reason string) *APIError {
+	return &APIError{Status: fiber.StatusUnauthorized, Code: CodeUnauthorized, Message: reason}
+}
+
+// ErrBadForum Forum requested is not configured
+var ErrBadForum = &APIError{Status: fiber.StatusBadRequest, Code: CodeBadParameter, Message: "Invalid Forum"}
+
+// badParameter Parameter name of the request is missing or malformed
+func badParameter(name string, err error) *APIError {
+	return &APIError{Status: fiber.StatusBadRequest, Code: CodeBadParameter, Message: "Invalid " + name, Err: err}
+}
+
+// invalid Parameters of the request are rejected by err, which is written for clients and sent as the message
+func invalid(err error) *APIError {
+	return &APIError{Status: fiber.StatusBadRequest, Code: CodeBadParameter, Message: err.Error()}
+}
+
+// notFound Resource requested does not exist
+func notFound(what string) *APIError {
+	return &APIError{Status: fiber.StatusNotFound, Code: CodeNotFound, Message: what + " Not Found"}
+}
+
+// conflict Record written conflicts with an existing one
+func conflict(err error) *APIError {
+	return &APIError{Status: fiber.StatusConflict, Code: CodeConflict, Message: err.Error()}
+}
+
+// upstream Request to an upstream site failed
+func upstream(name string, err error) *APIError {
+	return &APIError{
+		Status:  fiber.StatusBadGateway,
+		Code:    CodeUpstream + name,
+		Message: "Failed to get data from " + name,
+		Err:     err,
+	}
+}
+
+// upstreamTieba Request to tieba failed
+func upstreamTieba(err error) *APIError {
+	return upstream("tieba", err)
+}
+
+// upstreamIncome Request to chandashi for income failed
+func upstreamIncome(err error) *APIError {
+	return upstream("income", err)
+}
+
+// ErrorHandler Send errors returned by handlers as JSON, errors not meant for clients are logged as internal errors
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var apiErr *APIError
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &fiberErr):
+		apiErr = &APIError{Status: fiberErr.Code, Code: CodeInternal, Message: fiberErr.Message}
+		if fiberErr.Code == fiber.StatusNotFound {
+			apiErr.Code = CodeNotFound
+		} else if fiberErr.Code < fiber.StatusInternalServerError {
+			apiErr.Code = CodeBadParameter
+		}
+	case errors.Is(err, model.ErrNotFound):
+		apiErr = notFound("Record")
+	case errors.Is(err, model.ErrDuplicate):
+		apiErr = conflict(err)
+	default:
+		apiErr = &APIError{Status: fiber.StatusInternalServerError, Code: CodeInternal, Message: "Internal Server Error", Err: err}
+	}
+
+	if apiErr.Status >= fiber.StatusInternalServerError {
+		log.Printf("%v %v: %v", c.Method(), c.Path(), err)
+	}
+	return c.Status(apiErr.Status).JSON(apiErr)
+}
+
+// NotFound Handle requests matching no route
+func NotFound(c *fiber.Ctx) error {
+	return notFound("Route")
+}
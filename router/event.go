@@ -0,0 +1,312 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/model"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits of audit logs returned at once
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+// actor Name and key id of the API key making a change.
+// Changes are audited, so they need signed requests and legacy tokens are not accepted.
+func actor(c *fiber.Ctx) (string, error) {
+	key, ok := c.Locals(keyLocal).(model.APIKey)
+	if !ok {
+		return "", unauthorized("API Key Required")
+	}
+	return fmt.Sprintf("%v (%v)", key.Name, key.KeyId), nil
+}
+
+// paramId Get id of the record in path
+func paramId(c *fiber.Ctx) (uint, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 32)
+	if err != nil || id == 0 {
+		return 0, badParameter("Id", err)
+	}
+	return uint(id), nil
+}
+
+// queryLimit Get number of records requested by limit in query, def when it is missing and at most max
+func queryLimit(c *fiber.Ctx, def, max int) (int, error) {
+	value := c.Query("limit")
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, badParameter("Limit", err)
+	}
+	if n > max {
+		return max, nil
+	}
+	return n, nil
+}
+
+// parseDate Parse date formatted as C.DATEFMT in local time, like dates of records in store
+func parseDate(date string) (time.Time, error) {
+	return time.ParseInLocation(C.DATEFMT, date, time.Local)
+}
+
+func toEvent(info model.EventInfo) (model.Event, error) {
+	date, err := parseDate(info.Date)
+	if err != nil {
+		return model.Event{}, fmt.Errorf("date %q does not match %v", info.Date, C.DATEFMT)
+	}
+	event := strings.TrimSpace(info.Event)
+	if event == "" {
+		return model.Event{}, errors.New("event is empty")
+	}
+	return model.Event{Date: date, Event: event}, nil
+}
+
+func toAnniversary(info model.AnniversaryInfo) (model.Anniversary, error) {
+	if _, err := parseDate(info.Date); err != nil {
+		return model.Anniversary{}, fmt.Errorf("date %q does not match %v", info.Date, C.DATEFMT)
+	}
+	event := strings.TrimSpace(info.Event)
+	if event == "" {
+		return model.Anniversary{}, errors.New("event is empty")
+	}
+	return model.Anniversary{
+		Date:        info.Date,
+		Event:       event,
+		Adj:         strings.TrimSpace(info.Adj),
+		Description: strings.TrimSpace(info.Description),
+	}, nil
+}
+
+// CreateEvent Create an event
+func CreateEvent(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.EventInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	event, err := toEvent(info)
+	if err != nil {
+		return invalid(fmt.Errorf("event: %w", err))
+	}
+
+	events := []model.Event{event}
+	if err = store.CreateEvents(events, who); err != nil {
+		return err
+	}
+
+	c.Status(fiber.StatusCreated)
+	return c.JSON(fiber.Map{"event": events[0]})
+}
+
+// UpdateEvent Replace date and text of an event
+func UpdateEvent(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.EventInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	event, err := toEvent(info)
+	if err != nil {
+		return invalid(fmt.Errorf("event: %w", err))
+	}
+
+	event.Id = id
+	if err = store.UpdateEvent(&event, who); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"event": event})
+}
+
+// DeleteEvent Delete an event
+func DeleteEvent(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	if err = store.DeleteEvent(id, who); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ImportEvents Create events at once, none of them is created if any is invalid or duplicated
+func ImportEvents(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.ImportInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	if len(info.Events) == 0 {
+		return invalid(errors.New("no events to import"))
+	}
+
+	events := make([]model.Event, 0, len(info.Events))
+	for i, e := range info.Events {
+		event, err := toEvent(e)
+		if err != nil {
+			return invalid(fmt.Errorf("event %d: %w", i+1, err))
+		}
+		events = append(events, event)
+	}
+
+	if err = store.CreateEvents(events, who); err != nil {
+		return err
+	}
+
+	c.Status(fiber.StatusCreated)
+	return c.JSON(fiber.Map{"events": events})
+}
+
+// CreateAnniversary Create an anniversary
+func CreateAnniversary(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.AnniversaryInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	anniversary, err := toAnniversary(info)
+	if err != nil {
+		return invalid(fmt.Errorf("anniversary: %w", err))
+	}
+
+	anniversaries := []model.Anniversary{anniversary}
+	if err = store.CreateAnniversaries(anniversaries, who); err != nil {
+		return err
+	}
+
+	c.Status(fiber.StatusCreated)
+	return c.JSON(fiber.Map{"anniversary": anniversaries[0]})
+}
+
+// UpdateAnniversary Replace all fields of an anniversary
+func UpdateAnniversary(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.AnniversaryInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	anniversary, err := toAnniversary(info)
+	if err != nil {
+		return invalid(fmt.Errorf("anniversary: %w", err))
+	}
+
+	anniversary.Id = id
+	if err = store.UpdateAnniversary(&anniversary, who); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"anniversary": anniversary})
+}
+
+// DeleteAnniversary Delete an anniversary
+func DeleteAnniversary(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+	id, err := paramId(c)
+	if err != nil {
+		return err
+	}
+
+	if err = store.DeleteAnniversary(id, who); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ImportAnniversaries Create anniversaries at once, none of them is created if any is invalid or duplicated
+func ImportAnniversaries(c *fiber.Ctx) error {
+	who, err := actor(c)
+	if err != nil {
+		return err
+	}
+
+	var info model.ImportInfo
+	if err = c.BodyParser(&info); err != nil {
+		return badParameter("Body", err)
+	}
+	if len(info.Anniversaries) == 0 {
+		return invalid(errors.New("no anniversaries to import"))
+	}
+
+	anniversaries := make([]model.Anniversary, 0, len(info.Anniversaries))
+	for i, a := range info.Anniversaries {
+		anniversary, err := toAnniversary(a)
+		if err != nil {
+			return invalid(fmt.Errorf("anniversary %d: %w", i+1, err))
+		}
+		anniversaries = append(anniversaries, anniversary)
+	}
+
+	if err = store.CreateAnniversaries(anniversaries, who); err != nil {
+		return err
+	}
+
+	c.Status(fiber.StatusCreated)
+	return c.JSON(fiber.Map{"anniversaries": anniversaries})
+}
+
+// GetAuditLogs Get latest changes made by admins, filtered by entity when it is specified
+func GetAuditLogs(c *fiber.Ctx) error {
+	if _, err := actor(c); err != nil {
+		return err
+	}
+
+	limit, err := queryLimit(c, defaultAuditLimit, maxAuditLimit)
+	if err != nil {
+		return err
+	}
+
+	logs, err := store.GetAuditLogs(c.Query("entity"), limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{"logs": logs})
+}
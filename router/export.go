@@ -0,0 +1,89 @@
+package router
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/crawler"
+	"github.com/DRJ31/tiebarankgo/export"
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportSource Tables exported by handlers
+func exportSource() export.Source {
+	return export.Source{
+		Store: store,
+		Income: func(from, to time.Time) (model.IncomeData, error) {
+			return crawler.GetIncomeData(ctx, from, to)
+		},
+	}
+}
+
+// queryDate Parse optional date in query, zero is returned when it is missing
+func queryDate(c *fiber.Ctx, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return parseDate(value)
+}
+
+// Export Export a table as CSV or XLSX, rows are streamed to the client as they are read
+func Export(c *fiber.Ctx) error {
+	table := c.Params("table")
+	if !checkToken(c, table, c.Query("token")) {
+		return ErrBadToken
+	}
+
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
+	}
+	format := c.Query("format", export.FormatCSV)
+	if !export.ValidFormat(format) {
+		return invalid(fmt.Errorf("unknown format %q", format))
+	}
+	from, err := queryDate(c, "from")
+	if err != nil {
+		return badParameter("From", err)
+	}
+	to, err := queryDate(c, "to")
+	if err != nil {
+		return badParameter("To", err)
+	}
+
+	t, err := exportSource().Open(export.Query{
+		Table:   table,
+		Forum:   forum.Key,
+		From:    from,
+		To:      to,
+		Columns: export.ParseColumns(c.Query("columns")),
+	})
+	if errors.Is(err, export.ErrBadQuery) {
+		return invalid(err)
+	}
+	if err != nil && table == export.TableIncome {
+		return upstreamIncome(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, export.ContentType(format))
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%v-%v.%v"`, forum.Key, table, format))
+	// Status and headers are sent before rows, so errors while streaming can only be logged
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := export.Write(w, format, t); err != nil {
+			log.Printf("Export %v of %v: %v", table, forum.Key, err)
+			return
+		}
+		if err := w.Flush(); err != nil {
+			log.Printf("Export %v of %v: %v", table, forum.Key, err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,266 @@
+package router
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/model"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Entries published in a feed at once
+const (
+	defaultFeedLimit = 30
+	maxFeedLimit     = 365
+)
+
+// milestone Count of a post field announced every Step
+type milestone struct {
+	Name  string
+	Step  uint
+	Value func(post model.Post) uint
+}
+
+var milestones = []milestone{
+	{"members", 100000, func(post model.Post) uint { return post.Members }},
+	{"followers", 100000, func(post model.Post) uint { return post.Followers }},
+	{"posts", 1000000, func(post model.Post) uint { return post.Total }},
+}
+
+// feedEntry Entry of a feed, independent of its format
+type feedEntry struct {
+	Id      string
+	Title   string
+	Content string
+	Date    time.Time
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  string      `xml:"author>name"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	Id      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Content atomText `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+// groupDigits Format n with thousands separators
+func groupDigits(n uint) string {
+	s := strconv.FormatUint(uint64(n), 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// signedDelta Format change from prev to cur with its sign
+func signedDelta(cur, prev uint) string {
+	if cur >= prev {
+		return "+" + groupDigits(cur-prev)
+	}
+	return "-" + groupDigits(prev-cur)
+}
+
+// feedId Tag URI identifying an entry of forum on day
+func feedId(forum string, day time.Time, name string) string {
+	return fmt.Sprintf("tag:tiebarank,%v:%v/%v", day.Format(C.DATEFMT), forum, name)
+}
+
+// postEntry Entry of a daily snapshot, with changes since prev when it exists
+func postEntry(forum string, post model.Post, prev *model.Post) feedEntry {
+	fields := []struct {
+		Name  string
+		Value func(post model.Post) uint
+	}{
+		{"Posts", func(p model.Post) uint { return p.Total }},
+		{"Followers", func(p model.Post) uint { return p.Followers }},
+		{"Members", func(p model.Post) uint { return p.Members }},
+		{"VIP", func(p model.Post) uint { return p.Vip }},
+		{"Sign-ins", func(p model.Post) uint { return p.Signin }},
+	}
+
+	lines := make([]string, 0, len(fields))
+	for _, f := range fields {
+		line := fmt.Sprintf("%v: %v", f.Name, groupDigits(f.Value(post)))
+		if prev != nil {
+			line += fmt.Sprintf(" (%v)", signedDelta(f.Value(post), f.Value(*prev)))
+		}
+		lines = append(lines, line)
+	}
+
+	day := post.Date.Format(C.DATEFMT)
+	return feedEntry{
+		Id:      feedId(forum, post.Date, "post/"+day),
+		Title:   fmt.Sprintf("%v stats of %v", forum, day),
+		Content: strings.Join(lines, "\n"),
+		Date:    post.Date,
+	}
+}
+
+// milestoneEntries Entries of milestones crossed from prev to post
+func milestoneEntries(forum string, post, prev model.Post) []feedEntry {
+	entries := make([]feedEntry, 0)
+	for _, m := range milestones {
+		// Counts missing from older snapshots are not milestones
+		cur, old := m.Value(post)/m.Step, m.Value(prev)/m.Step
+		if m.Value(prev) == 0 || cur <= old {
+			continue
+		}
+		reached := cur * m.Step
+		entries = append(entries, feedEntry{
+			Id:      feedId(forum, post.Date, fmt.Sprintf("milestone/%v/%d", m.Name, reached)),
+			Title:   fmt.Sprintf("%v reached %v %v", forum, groupDigits(reached), m.Name),
+			Content: fmt.Sprintf("%v has %v %v on %v", forum, groupDigits(m.Value(post)), m.Name, post.Date.Format(C.DATEFMT)),
+			Date:    post.Date,
+		})
+	}
+	return entries
+}
+
+// feedEntries Entries of daily snapshots and milestones of forum, latest first
+func feedEntries(forum string, posts []model.Post, limit int) []feedEntry {
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].Date.Before(posts[j].Date)
+	})
+
+	entries := make([]feedEntry, 0, len(posts))
+	for i, post := range posts {
+		var prev *model.Post
+		if i > 0 {
+			prev = &posts[i-1]
+			// Milestones are listed above the stats of their day once reversed
+			entries = append(entries, milestoneEntries(forum, post, *prev)...)
+		}
+		entries = append(entries, postEntry(forum, post, prev))
+	}
+
+	// Reverse to latest first, keeping the order of entries of the same day
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// GetFeed Get daily stats and milestones of a forum as an Atom feed, or RSS with format=rss
+func GetFeed(c *fiber.Ctx) error {
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
+	}
+
+	limit, err := queryLimit(c, defaultFeedLimit, maxFeedLimit)
+	if err != nil {
+		return err
+	}
+
+	format := c.Query("format", "atom")
+	if format != "atom" && format != "rss" {
+		return invalid(fmt.Errorf("unknown format %q", format))
+	}
+
+	posts, err := store.GetPosts(forum.Key)
+	if err != nil {
+		return err
+	}
+	entries := feedEntries(forum.Key, posts, limit)
+
+	updated := time.Now()
+	if len(entries) > 0 {
+		updated = entries[0].Date
+	}
+	title := forum.Name + "吧 stats"
+	self := c.BaseURL() + c.OriginalURL()
+
+	var doc interface{}
+	if format == "rss" {
+		feed := rssFeed{Version: "2.0"}
+		feed.Channel.Title = title
+		feed.Channel.Link = self
+		feed.Channel.Description = "Daily stats and milestones of " + forum.Name + "吧"
+		feed.Channel.LastBuildDate = updated.Format(time.RFC1123Z)
+		for _, e := range entries {
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       e.Title,
+				Description: e.Content,
+				Guid:        rssGuid{Id: e.Id},
+				PubDate:     e.Date.Format(time.RFC1123Z),
+			})
+		}
+		doc = feed
+		c.Set(fiber.HeaderContentType, "application/rss+xml; charset=utf-8")
+	} else {
+		feed := atomFeed{
+			Title:   title,
+			Id:      "tag:tiebarank,2020-09-28:" + forum.Key,
+			Updated: updated.Format(time.RFC3339),
+			Link:    atomLink{Rel: "self", Href: self},
+			Author:  "tiebarank",
+		}
+		for _, e := range entries {
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   e.Title,
+				Id:      e.Id,
+				Updated: e.Date.Format(time.RFC3339),
+				Content: atomText{Type: "text", Text: e.Content},
+			})
+		}
+		doc = feed
+		c.Set(fiber.HeaderContentType, "application/atom+xml; charset=utf-8")
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Send(append([]byte(xml.Header), data...))
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/DRJ31/tiebarankgo/auth"
+	"github.com/DRJ31/tiebarankgo/config"
 	"github.com/DRJ31/tiebarankgo/crawler"
 	"github.com/DRJ31/tiebarankgo/model"
 	"github.com/DRJ31/tiebarankgo/secrets"
@@ -13,6 +15,7 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -26,6 +29,42 @@ func inArr(arr []string, str string) bool {
 	return false
 }
 
+// CancelCrawls Cancel crawls started by handlers, handlers waiting for them return with errors
+func CancelCrawls() {
+	cancelCrawls()
+}
+
+// getForum Get the forum requested by client, the first configured forum is used when not specified
+func getForum(key string) (config.Forum, bool) {
+	return conf.Get().GetForum(key)
+}
+
+// getUserDaily Get daily change of a user from snapshots sorted by date, the last snapshot of a day is used
+func getUserDaily(snapshots []model.UserSnapshot) []model.UserDaily {
+	result := make([]model.UserDaily, 0)
+
+	for _, snapshot := range snapshots {
+		day := snapshot.Date.Format(C.DATEFMT)
+		if len(result) > 0 && result[len(result)-1].Date == day {
+			result = result[:len(result)-1]
+		}
+		daily := model.UserDaily{
+			Date:  day,
+			Rank:  snapshot.Rank,
+			Level: snapshot.Level,
+			Exp:   snapshot.Exp,
+		}
+		if len(result) > 0 {
+			prev := result[len(result)-1]
+			daily.ExpGain = int(daily.Exp) - int(prev.Exp)
+			daily.RankChange = int(prev.Rank) - int(daily.Rank)
+		}
+		result = append(result, daily)
+	}
+
+	return result
+}
+
 func getDelta(newMap, oldMap map[uint]uint) []model.DistRet {
 	var result []model.DistRet
 
@@ -58,114 +97,135 @@ func getDelta(newMap, oldMap map[uint]uint) []model.DistRet {
 	return result
 }
 
-func getDist(level, rank uint, server string, ch chan model.DistRet, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	start := time.Now()
+// requestDist Ask a distribution server for the last rank of level
+func requestDist(server string, forum config.Forum, level, rank uint, timeout time.Duration) (model.DistInfo, error) {
+	var info model.DistInfo
 
-	token := secrets.Encrypt(C.SALT, strconv.FormatUint(uint64(rank), 10))
+	if !strings.HasPrefix(server, "http://") && !strings.HasPrefix(server, "https://") {
+		server = "http://" + server
+	}
 	url := fmt.Sprintf("%v/api/v2/tieba/rank", server)
-	var response []byte
-	var info model.DistInfo
 
 	rankInfo := model.RankInfo{
-		Token: token,
+		Token: secrets.Encrypt(C.SALT, strconv.FormatUint(uint64(rank), 10)),
+		Forum: forum.Key,
 		Rank:  rank,
 		Level: level,
 	}
 	jsonByte, err := json.Marshal(rankInfo)
 	if err != nil {
-		panic(err)
+		return info, err
 	}
 
-	client := &http.Client{}
-	res, err := client.Post(url, "application/json", bytes.NewBuffer(jsonByte))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonByte))
 	if err != nil {
-		log.Printf("Crawl err: %v", err)
-		return
+		return info, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cf := conf.Get(); cf.DistKeyId != "" {
+		auth.SignRequest(req, cf.DistKeyId, cf.DistSecret, jsonByte)
 	}
 
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return info, err
+	}
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
-		log.Printf("Status code err: %d %s", res.StatusCode, res.Status)
-		return
+		return info, &crawler.MyError{Message: fmt.Sprintf("%d %s", res.StatusCode, res.Status)}
 	}
 
-	response, err = io.ReadAll(res.Body)
+	response, err := io.ReadAll(res.Body)
 	if err != nil {
-		log.Println(err)
-		return
+		return info, err
 	}
-
-	err = json.Unmarshal(response, &info)
-	if err != nil {
-		panic(err)
-		return
+	if err = json.Unmarshal(response, &info); err != nil {
+		return info, err
 	}
-
-	result := model.DistRet{
-		Rank:  info.Rank,
-		Level: info.Level,
-		Delta: int(info.Rank),
+	if info.Level != level {
+		return info, &crawler.MyError{Message: fmt.Sprintf("expected level %d, got %d", level, info.Level)}
 	}
 
-	elapsed := time.Since(start)
-	log.Println(server, level, elapsed)
-	ch <- result
+	return info, nil
 }
 
-func convertDivider(old map[uint]uint) map[uint]uint {
-	var tmp []model.DistInfo
-	mp := make(map[uint]uint)
-
-	for k, v := range old {
-		tmp = append(tmp, model.DistInfo{
-			Level: k,
-			Rank:  v,
-		})
+// getDist Get the last rank of level from distribution servers, falls back to crawling locally if all of them fail
+func getDist(forum config.Forum, level, rank uint) (model.DistRet, error) {
+	cf := conf.Get()
+	timeout := time.Duration(cf.DistTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
 	}
 
-	sort.Slice(tmp, func(i, j int) bool {
-		return tmp[i].Level > tmp[j].Level
-	})
-
-	var sum uint = 0
-	for i := range tmp {
-		if i > 0 {
-			tmp[i].Rank -= sum
+	start := time.Now()
+	for _, server := range cf.DistributeServers(level) {
+		for i := 0; i <= cf.DistRetries; i++ {
+			info, err := requestDist(server, forum, level, rank, timeout)
+			if err == nil {
+				log.Println(server, level, time.Since(start))
+				return model.DistRet{Level: level, Rank: info.Rank, Delta: int(info.Rank)}, nil
+			}
+			log.Printf("Distribution server %v failed on level %d (attempt %d): %v", server, level, i+1, err)
+			if ctx.Err() != nil {
+				// Crawls are canceled on shutdown, the local fallback would fail as well
+				return model.DistRet{}, ctx.Err()
+			}
+			if i < cf.DistRetries {
+				if err = crawler.Sleep(ctx, time.Duration(i+1)*time.Second); err != nil {
+					return model.DistRet{}, err
+				}
+			}
 		}
-		sum += tmp[i].Rank
 	}
 
-	for _, elem := range tmp {
-		mp[elem.Level] = elem.Rank
+	boundary, err := crawler.FindBoundary(ctx, forum, level, rank)
+	if err != nil {
+		log.Printf("Local distribution failed on level %d: %v", level, err)
+		return model.DistRet{}, err
 	}
-	return mp
+	log.Println("local", level, time.Since(start))
+	return model.DistRet{Level: level, Rank: boundary.Rank, Delta: int(boundary.Rank)}, nil
 }
 
-func parseIncomeData(incomeData model.IncomeData) ([]model.Income, uint) {
+// parseIncomeData Get daily incomes and the average income, the average series must be present
+func parseIncomeData(incomeData model.IncomeData) ([]model.Income, uint, error) {
 	incomes := make([]model.Income, 0)
 
-	for _, data := range incomeData.Data.Points[0].Data {
+	for _, data := range incomeData.Series(0) {
 		incomes = append(incomes, model.Income{Date: data[0], Income: data[1]})
 	}
 
-	return incomes, incomeData.Data.Points[1].Data[0][1]
+	average := incomeData.Series(1)
+	if len(average) == 0 {
+		return nil, 0, &crawler.MyError{Message: "no average in income data"}
+	}
+	return incomes, average[0][1], nil
 }
 
 func refreshData(income *model.UpIncome, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	if err := computeIncome(income); err != nil {
+		log.Println(err)
+	}
+}
+
+// computeIncome Set Income and Max of a banner from income of its first days
+func computeIncome(income *model.UpIncome) error {
 	var max uint = 0
 	var sum uint = 0
 
-	incomeData, err := crawler.GetIncomeData(income.Date, income.Date.Add(4*24*time.Hour))
+	incomeData, err := crawler.GetIncomeData(ctx, income.Date, income.Date.Add(4*24*time.Hour))
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 
-	for _, data := range incomeData.Data.Points[0].Data {
+	points := incomeData.Series(0)
+	if len(points) == 0 {
+		return &crawler.MyError{Message: fmt.Sprintf("no income of banner on %v", income.Date.Format(C.DATEFMT))}
+	}
+	for _, data := range points {
 		sum += data[1]
 		if data[1] > max {
 			max = data[1]
@@ -174,20 +234,21 @@ func refreshData(income *model.UpIncome, wg *sync.WaitGroup) {
 
 	income.Income = sum
 	income.Max = max
+	return nil
 }
 
-func getMonthIncome() []model.MonthIncome {
+func getMonthIncome() ([]model.MonthIncome, error) {
 	startDate, _ := time.Parse(C.SHORT_DATE, "20200928")
 	current := "202009"
 	monthIncome := model.MonthIncome{Date: current, Income: 0}
 	incomes := make([]model.MonthIncome, 0)
 
-	incomeData, err := crawler.GetIncomeData(startDate, time.Now())
+	incomeData, err := crawler.GetIncomeData(ctx, startDate, time.Now())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	for _, data := range incomeData.Data.Points[0].Data {
+	for _, data := range incomeData.Series(0) {
 		currentMonth := time.Unix(int64(data[0])/1000, 0).Format(C.MONTHFMT)
 		if currentMonth != current {
 			incomes = append(incomes, monthIncome)
@@ -200,5 +261,5 @@ func getMonthIncome() []model.MonthIncome {
 
 	incomes = append(incomes, monthIncome)
 
-	return incomes
+	return incomes, nil
 }
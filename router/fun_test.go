@@ -0,0 +1,39 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+func TestParseIncomeData(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		incomes int
+		average uint
+		wantErr bool
+	}{
+		{"complete", `{"data":{"points":[{"data":[[1,10],[2,20]]},{"data":[[0,15]]}]}}`, 2, 15, false},
+		{"short pair", `{"data":{"points":[{"data":[[1,10],[2]]},{"data":[[0,10]]}]}}`, 1, 10, false},
+		{"no average", `{"data":{"points":[{"data":[[1,10]]}]}}`, 0, 0, true},
+		{"empty average", `{"data":{"points":[{"data":[]},{"data":[]}]}}`, 0, 0, true},
+		{"no points", `{"data":{}}`, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data model.IncomeData
+			if err := json.Unmarshal([]byte(tt.body), &data); err != nil {
+				t.Fatal(err)
+			}
+			incomes, average, err := parseIncomeData(data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if len(incomes) != tt.incomes || average != tt.average {
+				t.Errorf("got %d incomes and average %d, want %d and %d", len(incomes), average, tt.incomes, tt.average)
+			}
+		})
+	}
+}
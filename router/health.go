@@ -0,0 +1,113 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/crawler"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	checkTimeout = 2 * time.Second
+	probeTimeout = 5 * time.Second
+	probeTTL     = time.Minute // Time a probe of tieba is reused
+)
+
+// Check Status of a dependency
+type Check struct {
+	Status  string  `json:"status"` // ok or error
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+	Cached  bool    `json:"cached,omitempty"` // Result of an earlier probe
+}
+
+var tiebaProbe = struct {
+	sync.Mutex
+	check Check
+	at    time.Time
+}{}
+
+func runCheck(ping func(ctx context.Context) error, timeout time.Duration) Check {
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := ping(checkCtx)
+	check := Check{Status: "ok", Latency: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		check.Status = "error"
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// probeTieba Check that tieba is reachable, results are reused for probeTTL
+func probeTieba() Check {
+	tiebaProbe.Lock()
+	defer tiebaProbe.Unlock()
+
+	if !tiebaProbe.at.IsZero() && time.Since(tiebaProbe.at) < probeTTL {
+		check := tiebaProbe.check
+		check.Cached = true
+		return check
+	}
+
+	forum, _ := getForum("")
+	tiebaProbe.check = runCheck(func(ctx context.Context) error {
+		return crawler.Probe(ctx, forum)
+	}, probeTimeout)
+	tiebaProbe.at = time.Now()
+	return tiebaProbe.check
+}
+
+// Healthz Report that the server is alive
+func Healthz(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// Readyz Report whether the database, cache and optionally tieba are reachable
+func Readyz(c *fiber.Ctx) error {
+	cf := conf.Get()
+	storage, cacheName := cf.Storage, cf.Cache
+	if storage == "" {
+		storage = "mysql"
+	}
+	if cacheName == "" {
+		cacheName = "redis"
+	}
+
+	checks := make(map[string]Check)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	run := func(name string, check func() Check) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check()
+			mu.Lock()
+			checks[name] = result
+			mu.Unlock()
+		}()
+	}
+
+	run(storage, func() Check { return runCheck(store.Ping, checkTimeout) })
+	run(cacheName, func() Check { return runCheck(cache.Ping, checkTimeout) })
+	if cf.ProbeTieba {
+		run("tieba", probeTieba)
+	}
+	wg.Wait()
+
+	status := "ok"
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status = "error"
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+	}
+	return c.JSON(fiber.Map{
+		"status": status,
+		"checks": checks,
+	})
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/metrics"
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// clientId Identify client by its API key, or by IP for unsigned requests
+func clientId(c *fiber.Ctx) string {
+	if key, ok := c.Locals(keyLocal).(model.APIKey); ok {
+		return "key:" + key.KeyId
+	}
+	return "ip:" + c.IP()
+}
+
+// rateLimitWindow Limit of the route of c, the key counting requests of client in the current window and the window index.
+// ok is false when the route is not limited.
+func rateLimitWindow(c *fiber.Ctx, client string, now time.Time) (config.RateLimit, string, int64, bool) {
+	route := c.Route().Path
+	limit, ok := conf.Get().GetRateLimit(c.Method(), route)
+	if !ok {
+		return limit, "", 0, false
+	}
+	index := now.Unix() / int64(limit.Window)
+	// Methods sharing a path are counted apart
+	return limit, model.RateLimitKey(c.Method()+" "+route, client, index), index, true
+}
+
+// checkRateLimit Count request of the client to the route in the current window, requests over the limit are rejected.
+// Requests are let through when the cache fails.
+func checkRateLimit(c *fiber.Ctx) error {
+	now := time.Now()
+	limit, key, index, ok := rateLimitWindow(c, clientId(c), now)
+	if !ok {
+		return nil
+	}
+
+	window := time.Duration(limit.Window) * time.Second
+	count, err := cache.Incr(ctx, key, 1, window)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	remaining := int64(limit.Requests) - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Set("X-RateLimit-Limit", strconv.Itoa(limit.Requests))
+	c.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	if count <= int64(limit.Requests) {
+		return nil
+	}
+
+	retryAfter := (index+1)*int64(limit.Window) - now.Unix()
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(retryAfter, 10))
+	metrics.RateLimited(c.Route().Path)
+	return &APIError{
+		Status:  fiber.StatusTooManyRequests,
+		Code:    CodeRateLimited,
+		Message: fmt.Sprintf("Too Many Requests, retry after %d seconds", retryAfter),
+	}
+}
+
+// refundRateLimit Take back a request of client counted in the current window
+func refundRateLimit(c *fiber.Ctx, client string) {
+	limit, key, _, ok := rateLimitWindow(c, client, time.Now())
+	if !ok {
+		return
+	}
+	if _, err := cache.Incr(ctx, key, -1, time.Duration(limit.Window)*time.Second); err != nil {
+		log.Println(err)
+	}
+}
+
+// RateLimit Apply rate limits of the route to public routes, which are not guarded by Auth
+func RateLimit(c *fiber.Ctx) error {
+	if err := checkRateLimit(c); err != nil {
+		return err
+	}
+	return c.Next()
+}
@@ -0,0 +1,146 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/crawler"
+	"github.com/DRJ31/tiebarankgo/metrics"
+	"github.com/DRJ31/tiebarankgo/model"
+)
+
+const (
+	pageFresh   = time.Minute      // Time a cached page is served without refreshing
+	pageStale   = 30 * time.Minute // Time a stale page is still served while it is refreshed
+	pageLockTTL = 2 * time.Minute  // Longest time another instance is waited for to crawl a page
+	pagePoll    = 500 * time.Millisecond
+)
+
+// cachedPage Users in a furank page saved in cache
+type cachedPage struct {
+	Users      []model.TiebaUser `json:"users"`
+	FreshUntil time.Time         `json:"fresh_until"`
+}
+
+// pageCall Crawl of a furank page shared by all requests of the page
+type pageCall struct {
+	done  chan struct{}
+	users []model.TiebaUser
+	err   error
+}
+
+var pageFlight = struct {
+	sync.Mutex
+	calls map[string]*pageCall
+}{
+	calls: make(map[string]*pageCall),
+}
+
+// getPage Get users in a furank page of forum. Stale pages are returned at once
+// and refreshed in background, missing pages are crawled once for all requests.
+func getPage(forum config.Forum, page uint64) ([]model.TiebaUser, error) {
+	var cached cachedPage
+	err := cache.Get(ctx, model.PageKey(forum.Key, page), &cached)
+	if err == nil {
+		if time.Now().After(cached.FreshUntil) {
+			metrics.CacheLookup("page", "stale")
+			startCrawlPage(forum, page)
+		} else {
+			metrics.CacheLookup("page", "hit")
+		}
+		return cached.Users, nil
+	}
+	if !errors.Is(err, model.ErrCacheMiss) {
+		log.Println(err)
+	}
+	metrics.CacheLookup("page", "miss")
+
+	call := startCrawlPage(forum, page)
+	<-call.done
+	return call.users, call.err
+}
+
+// startCrawlPage Start crawling a page, the crawl already running in this instance is joined instead
+func startCrawlPage(forum config.Forum, page uint64) *pageCall {
+	key := model.PageKey(forum.Key, page)
+
+	pageFlight.Lock()
+	defer pageFlight.Unlock()
+	if call, ok := pageFlight.calls[key]; ok {
+		return call
+	}
+
+	call := &pageCall{done: make(chan struct{})}
+	pageFlight.calls[key] = call
+	go func() {
+		call.users, call.err = crawlPage(forum, page)
+		if call.err != nil {
+			log.Printf("Crawl page %d of %v failed: %v", page, forum.Key, call.err)
+		}
+
+		pageFlight.Lock()
+		delete(pageFlight.calls, key)
+		pageFlight.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// crawlPage Crawl a page and renew its users, instances sharing the cache take turns with a lock
+func crawlPage(forum config.Forum, page uint64) ([]model.TiebaUser, error) {
+	key := model.PageKey(forum.Key, page)
+	lockKey := model.PageLockKey(forum.Key, page)
+	start := time.Now()
+
+	// Token tells this crawl's lock apart from one taken after it expired
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(random)
+	locked, err := cache.SetNX(ctx, lockKey, token, pageLockTTL)
+	if err != nil {
+		// Crawl without lock rather than failing the request
+		log.Println(err)
+	} else if !locked {
+		// Another instance is crawling the page, wait for its result
+		for time.Since(start) < pageLockTTL {
+			if err = crawler.Sleep(ctx, pagePoll); err != nil {
+				return nil, err
+			}
+			var cached cachedPage
+			if cache.Get(ctx, key, &cached) == nil && cached.FreshUntil.After(start) {
+				return cached.Users, nil
+			}
+			if locked, err = cache.SetNX(ctx, lockKey, token, pageLockTTL); err != nil || locked {
+				break
+			}
+		}
+	}
+	if locked {
+		defer func() {
+			if _, err := cache.DeleteValue(ctx, lockKey, token); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+
+	users, err := crawler.GetUsers(ctx, forum, uint(page), store)
+	if err != nil {
+		return nil, err
+	}
+	if err = store.SaveUsers(forum.Key, users, time.Now()); err != nil {
+		log.Println(err)
+	}
+
+	cached := cachedPage{Users: users, FreshUntil: time.Now().Add(pageFresh)}
+	if err = cache.Set(ctx, key, cached, pageStale); err != nil {
+		log.Println(err)
+	}
+	return users, nil
+}
@@ -5,46 +5,58 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/DRJ31/tiebarankgo/config"
 	"github.com/DRJ31/tiebarankgo/crawler"
 	"github.com/DRJ31/tiebarankgo/model"
-	"github.com/DRJ31/tiebarankgo/secrets"
 	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+	"github.com/DRJ31/tiebarankgo/task"
 	"github.com/gofiber/fiber/v2"
-	"gorm.io/gorm"
 	"log"
-	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"sync"
 	"time"
 )
 
-var ctx = context.Background()
+// ctx Context of crawls started by handlers, canceled on shutdown
+var ctx, cancelCrawls = context.WithCancel(context.Background())
+
+var (
+	conf  *config.Live
+	store model.Store
+	cache model.Cache
+)
+
+// Setup Set config, store and cache used by handlers
+func Setup(cf *config.Live, s model.Store, c model.Cache) {
+	conf = cf
+	store = s
+	cache = c
+}
 
 // GetUsers Get users of a page
 func GetUsers(c *fiber.Ctx) error {
 	// Check token
 	token := c.Query("token")
 	pg := c.Query("page")
-	if !secrets.TokenCheck(C.SALT, pg, token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+	if !checkToken(c, pg, token) {
+		return ErrBadToken
 	}
 
-	// Initialize Redis
-	rdb := model.InitRedis()
-	defer rdb.Close()
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
+	}
 
 	// Get page information
 	page, err := strconv.ParseUint(pg, C.BASE, C.BITSIZE)
 	if err != nil {
-		log.Printf("Page parse err: %v", err)
-		return err
+		return badParameter("Page", err)
 	}
 	pageSize, err := strconv.ParseUint(c.Query("pageSize"), C.BASE, C.BITSIZE)
 	if err != nil {
-		log.Printf("PageSize parse err: %v", err)
-		return err
+		return badParameter("Page Size", err)
 	}
 
 	// Check page number with different page size
@@ -56,78 +68,30 @@ func GetUsers(c *fiber.Ctx) error {
 		}
 	}
 
-	// Get total number of genshin tieba member
-	total, err := rdb.Get(ctx, "tieba_genshin_member_total").Uint64()
-	if err != nil {
+	// Get total number of tieba member
+	var total uint64
+	if err = cache.Get(ctx, model.MemberTotalKey(forum.Key), &total); err != nil {
 		total = C.MINUSER
 	}
 
-	// Check if the users in the page are cached
-	byteUsers, err := rdb.Get(ctx, "tieba_genshin_page_"+strconv.FormatUint(realPage, 10)).Bytes()
-	var users []model.TiebaUser
+	// Users are renewed in store when the page is crawled
+	users, err := getPage(forum, realPage)
 	if err != nil {
-		log.Println(err)
-		users, err = crawler.GetUsers(C.TIEBA, uint(realPage))
-		if err != nil {
-			return err
-		}
-		byteUsers, _ = json.Marshal(users)
-		rdb.Set(ctx, "tieba_genshin_page_"+strconv.FormatUint(realPage, 10), byteUsers, time.Minute)
-	} else {
-		err = json.Unmarshal(byteUsers, &users)
-		if err != nil {
-			log.Println("Unmarshal user failed")
-			users, err = crawler.GetUsers(C.TIEBA, uint(realPage))
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	// Initialize database
-	db, err := model.Init()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	defer model.Close(db)
-
-	// Renew user information
-	uss := make([]model.User, 0, 20)
-	for _, user := range users {
-		var oldUser model.User
-		res := db.First(&oldUser, "name = ?", user.Name)
-		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-			uss = append(uss, model.User{
-				Rank:     user.Rank,
-				Level:    user.Level,
-				Exp:      user.Exp,
-				Member:   user.Member,
-				Link:     user.Link,
-				Name:     user.Name,
-				Nickname: user.Nickname,
-			})
-		} else {
-			db.Model(&oldUser).Updates(model.User{
-				Rank:     user.Rank,
-				Level:    user.Level,
-				Exp:      user.Exp,
-				Member:   user.Member,
-				Nickname: user.Nickname,
-			})
-		}
-	}
-	if len(uss) > 0 {
-		db.Create(&uss)
+		return upstreamTieba(err)
 	}
 
 	// Decide how many data to display according to page size
+	// Short pages, e.g. the last one, may not have a second half
 	var result []model.TiebaUser
 	if pageSize == 10 {
+		half := 10
+		if len(users) < half {
+			half = len(users)
+		}
 		if page%2 != 0 {
-			result = users[:10]
+			result = users[:half]
 		} else {
-			result = users[10:]
+			result = users[half:]
 		}
 	} else {
 		result = users
@@ -144,52 +108,75 @@ func GetUser(c *fiber.Ctx) error {
 	var ul model.UserLink
 
 	if err := c.BodyParser(&ul); err != nil {
-		return err
+		return badParameter("Body", err)
+	}
+
+	if !checkToken(c, ul.Link, ul.Token) {
+		return ErrBadToken
 	}
 
-	if !secrets.TokenCheck(C.SALT, ul.Link, ul.Token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+	forum, ok := getForum(ul.Forum)
+	if !ok {
+		return ErrBadForum
 	}
 
 	// Get user information
-	result, err := crawler.GetUser(ul.Link)
+	result, err := crawler.GetUser(ctx, ul.Link)
+	if errors.Is(err, crawler.ErrUserNotFound) {
+		return notFound("User")
+	}
 	if err != nil {
-		return err
+		return upstreamTieba(err)
+	}
+
+	user, err := store.GetUserByLink(forum.Key, ul.Link)
+	if err == nil && result.Nickname != user.Nickname {
+		if err = store.UpdateNickname(user, result.Nickname); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"user": result,
+	})
+}
+
+// GetUserHistory Get ranking history of a user
+func GetUserHistory(c *fiber.Ctx) error {
+	token := c.Query("token")
+	name, err := url.PathUnescape(c.Params("name"))
+	if err != nil || !checkToken(c, name, token) {
+		return ErrBadToken
 	}
 
-	// Initialize database
-	db, err := model.Init()
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
+	}
+
+	snapshots, err := store.GetUserSnapshots(forum.Key, name)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer model.Close(db)
-
-	var user model.User
-	db.First(&user, "link = ?", ul.Link)
-	if result.Nickname != user.Nickname {
-		db.Model(&user).Updates(model.User{Nickname: result.Nickname})
+	if len(snapshots) == 0 {
+		return notFound("User")
 	}
 
 	return c.JSON(fiber.Map{
-		"user": result,
+		"name":    name,
+		"history": snapshots,
+		"daily":   getUserDaily(snapshots),
 	})
 }
 
 // GetAnniversaries Get all anniversaries
 func GetAnniversaries(c *fiber.Ctx) error {
-	var anniversaries []model.Anniversary
-
-	// Initialize database
-	db, err := model.Init()
+	anniversaries, err := store.GetAnniversaries()
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer model.Close(db)
-
-	db.Find(&anniversaries)
 
 	return c.JSON(fiber.Map{"anniversaries": anniversaries})
 }
@@ -198,40 +185,32 @@ func GetAnniversaries(c *fiber.Ctx) error {
 func GetEvent(c *fiber.Ctx) error {
 	token := c.Query("token")
 	day := c.Query("date")
-	if !secrets.TokenCheck(C.SALT, day, token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+	if !checkToken(c, day, token) {
+		return ErrBadToken
 	}
 
 	d, err := time.Parse(C.DATEFMT, day)
 	if err != nil {
-		log.Println(err)
-		return err
+		return badParameter("Date", err)
 	}
 
-	// Initialize database
-	db, err := model.Init()
+	events := make([]string, 0)
+	data, err := store.GetEventsOn(d)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer model.Close(db)
-
-	events := make([]string, 0)
-	var data []model.Event
-	result := db.Find(&data, "date = ?", d.Format(C.DATEFMT))
-	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		for _, e := range data {
-			events = append(events, e.Event)
-		}
+	for _, e := range data {
+		events = append(events, e.Event)
 	}
 
-	var upIncome []model.UpIncome
-	result = db.Find(&upIncome, "date = ?", d.Format(C.DATEFMT))
-	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		for _, e := range upIncome {
-			events = append(events, e.Name+"池")
-		}
+	upIncome, err := store.GetIncomesOn(d)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	for _, e := range upIncome {
+		events = append(events, e.Name+"池")
 	}
 
 	return c.JSON(fiber.Map{"event": events})
@@ -239,26 +218,23 @@ func GetEvent(c *fiber.Ctx) error {
 
 // GetEvents Get all events
 func GetEvents(c *fiber.Ctx) error {
-	db, err := model.Init()
+	var days, event []string
+	var results []model.EventRet
+
+	data, err := store.GetEventsOn(time.Now())
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer model.Close(db)
-
-	var days, event []string
-	var results []model.EventRet
-	var data []model.Event
-	var upIncome []model.UpIncome
-
-	res := db.Find(&data, "date = ?", time.Now().Format(C.DATEFMT))
-	if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
-		for _, e := range data {
-			event = append(event, e.Event)
-		}
+	for _, e := range data {
+		event = append(event, e.Event)
 	}
 
-	db.Find(&data)
+	data, err = store.GetEvents()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	for _, e := range data {
 		dayStr := e.Date.Format(C.DATEFMT)
 		results = append(results, model.EventRet{
@@ -270,7 +246,11 @@ func GetEvents(c *fiber.Ctx) error {
 		}
 	}
 
-	db.Find(&upIncome)
+	upIncome, err := store.GetIncomes()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	for _, d := range upIncome {
 		dayStr := d.Date.Format(C.DATEFMT)
 		results = append(results, model.EventRet{
@@ -297,15 +277,18 @@ func GetEvents(c *fiber.Ctx) error {
 func GetOnePost(c *fiber.Ctx) error {
 	token := c.Query("token")
 	date := c.Query("date")
-	if !secrets.TokenCheck(C.SALT, date, token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+	if !checkToken(c, date, token) {
+		return ErrBadToken
+	}
+
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
 	}
 
-	posts, _, err := crawler.GetTotal()
+	posts, _, err := crawler.GetTotal(ctx, forum)
 	if err != nil {
-		log.Println(err)
-		return err
+		return upstreamTieba(err)
 	}
 
 	return c.JSON(fiber.Map{"total": posts})
@@ -315,21 +298,21 @@ func GetOnePost(c *fiber.Ctx) error {
 func GetMultiplePosts(c *fiber.Ctx) error {
 	token := c.Query("token")
 	page := c.Query("page")
-	if !secrets.TokenCheck(C.SALT, page, token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+	if !checkToken(c, page, token) {
+		return ErrBadToken
+	}
+
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
 	}
 
-	db, err := model.Init()
+	var results []model.PostRet
+	data, err := store.GetPosts(forum.Key)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer model.Close(db)
-
-	var data []model.Post
-	var results []model.PostRet
-	db.Order("date desc").Find(&data)
 
 	for _, d := range data {
 		results = append(results, model.PostRet{
@@ -345,390 +328,307 @@ func GetMultiplePosts(c *fiber.Ctx) error {
 func FindUsers(c *fiber.Ctx) error {
 	token := c.Query("token")
 	keyword := c.Query("keyword")
-	if !secrets.TokenCheck(C.SALT, keyword, token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+	if !checkToken(c, keyword, token) {
+		return ErrBadToken
 	}
 
-	keyword = "%" + keyword + "%"
-	var users []model.User
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
+	}
 
-	db, err := model.Init()
+	users, err := store.FindUsers(forum.Key, keyword)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer model.Close(db)
-
-	db.Where("name LIKE ?", keyword).Or("nickname LIKE ?", keyword).Find(&users)
 
 	return c.JSON(fiber.Map{"users": users})
 }
 
 // GetRank Get distribution of specific rank
-//func GetRank(c *fiber.Ctx) error {
-//	var info model.RankInfo
-//	err := c.BodyParser(&info)
-//	if err != nil {
-//		log.Println(err)
-//		return err
-//	}
-//
-//	var MAXTHREAD = runtime.NumCPU() * C.THREADS
-//	var wg sync.WaitGroup
-//	var min uint = 0
-//
-//	if !secrets.TokenCheck(C.SALT, strconv.FormatUint(uint64(info.Rank), 10), info.Token) {
-//		c.Status(400)
-//		return c.JSON(fiber.Map{"message": "Invalid Request"})
-//	}
-//
-//	sp := math.Ceil(float64(info.Rank) / 20) // Float format of startPage
-//	startPage := uint(sp)
-//
-//	for {
-//		ch := make(chan uint)
-//		for i := 0; i < MAXTHREAD; i++ {
-//			wg.Add(1)
-//			go crawler.GetDistribution(C.TIEBA, int(startPage)+i, info.Level, ch, &wg)
-//		}
-//		go func() {
-//			wg.Wait()
-//			close(ch)
-//		}()
-//		for rank := range ch {
-//			if min == 0 || rank < min {
-//				min = rank
-//			}
-//		}
-//		if min > 0 {
-//			break
-//		}
-//		startPage += uint(MAXTHREAD)
-//	}
-//
-//	return c.JSON(fiber.Map{
-//		"rank":  min,
-//		"level": info.Level,
-//	})
-//}
-
-//func GetDist(c *fiber.Ctx) error {
-//	token := c.Query("token")
-//	dateStr := c.Query("date")
-//	if !secrets.TokenCheck(C.SALT, dateStr, token) {
-//		c.Status(400)
-//		return c.JSON(fiber.Map{"message": "Invalid Request"})
-//	}
-//
-//	day, err := time.Parse(C.DATEFMT, dateStr)
-//	if err != nil {
-//		log.Println(err)
-//		return err
-//	}
-//
-//	// Initialize database
-//	db, err := model.Init()
-//	if err != nil {
-//		log.Println(err)
-//		return err
-//	}
-//	defer model.Close(db)
-//
-//	currentDate := time.Now().Add(time.Hour * 8).Truncate(time.Hour * 24)
-//	var oldDivider map[uint]uint
-//	if day.Equal(currentDate) {
-//		rdb := model.InitRedis()
-//		defer rdb.Close()
-//
-//		var firstDivider model.Divider
-//		var firstUser model.User
-//		var dividers []model.Divider
-//		var history model.History
-//
-//		db.Order("level desc").First(&firstDivider)
-//		db.Order("level desc").First(&firstUser)
-//
-//		if firstDivider.Level < firstUser.Level {
-//			db.Create(&model.Divider{
-//				Level: firstUser.Level,
-//				Rank:  1,
-//			})
-//		}
-//
-//		db.Order("level desc").Find(&dividers)
-//		lastDay := day.Add(time.Duration(-24) * time.Hour)
-//		res := db.First(&history, "date = ?", lastDay.Format(C.DATEFMT))
-//		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-//			log.Println(res.Error)
-//			return res.Error
-//		}
-//		err = json.Unmarshal([]byte(history.Distribution), &oldDivider)
-//		if err != nil {
-//			return err
-//		}
-//
-//		var dist []model.DistRet
-//		if byteDivider, err := rdb.Get(ctx, "tieba_genshin_divider").Bytes(); err != nil {
-//			var wg sync.WaitGroup
-//			ch := make(chan model.DistRet)
-//
-//			for _, div := range dividers {
-//				wg.Add(1)
-//				go getDist(div.Level, div.Rank, secrets.DistributeServer(div.Level), ch, &wg)
-//			}
-//			go func() {
-//				wg.Wait()
-//				close(ch)
-//			}()
-//
-//			newDivider := make(map[uint]uint)
-//			for dr := range ch {
-//				newDivider[dr.Level] = dr.Rank
-//				db.Model(&model.Divider{}).Where("level = ?", dr.Level).Update("rank", dr.Rank)
-//			}
-//			dist = getDelta(convertDivider(newDivider), oldDivider)
-//		} else {
-//			err = json.Unmarshal(byteDivider, &dist)
-//			if err != nil {
-//				log.Println(err)
-//				return err
-//			}
-//		}
-//
-//		posts, members, err := crawler.GetTotal()
-//		if err != nil {
-//			log.Println(err)
-//			return err
-//		}
-//
-//		membership, err := rdb.Get(ctx, "tieba_genshin_member_total").Uint64()
-//		if err != nil {
-//			log.Println(err)
-//			return err
-//		}
-//
-//		var users []model.User
-//		resp := db.Find(&users, "member = ?", 1)
-//
-//		sort.Slice(dist, func(i, j int) bool {
-//			return dist[i].Level > dist[j].Level
-//		})
-//
-//		byteDist, err := json.Marshal(dist)
-//		rdb.Set(ctx, "tieba_genshin_divider", byteDist, 10*time.Minute)
-//
-//		return c.JSON(fiber.Map{
-//			"distribution": dist,
-//			"total":        members,
-//			"membership":   membership,
-//			"vip":          resp.RowsAffected,
-//			"posts":        posts,
-//			"signin":       0,
-//		})
-//	} else {
-//		lastDay := day.Add(time.Duration(-24) * time.Hour)
-//		var oldHistory, newHistory model.History
-//		var newDivider map[uint]uint
-//		var postInfo model.Post
-//
-//		res := db.First(&newHistory, "date = ?", day.Format(C.DATEFMT))
-//		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-//			log.Println(res.Error)
-//			return res.Error
-//		}
-//
-//		res = db.First(&oldHistory, "date = ?", lastDay.Format(C.DATEFMT))
-//		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-//			log.Println(res.Error)
-//			return res.Error
-//		}
-//
-//		err = json.Unmarshal([]byte(newHistory.Distribution), &newDivider)
-//		if err != nil {
-//			return err
-//		}
-//		err = json.Unmarshal([]byte(oldHistory.Distribution), &oldDivider)
-//		if err != nil {
-//			return err
-//		}
-//
-//		result := getDelta(newDivider, oldDivider)
-//
-//		res = db.First(&postInfo, "date = ?", day.Format(C.DATEFMT))
-//		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-//			log.Println(res.Error)
-//			return res.Error
-//		}
-//
-//		sort.Slice(result, func(i, j int) bool {
-//			return result[i].Level > result[j].Level
-//		})
-//
-//		return c.JSON(fiber.Map{
-//			"distribution": result,
-//			"total":        postInfo.Followers,
-//			"membership":   postInfo.Members,
-//			"vip":          postInfo.Vip,
-//			"posts":        postInfo.Total,
-//			"signin":       postInfo.Signin,
-//		})
-//	}
-//}
-
-//func InsertUsers(c *fiber.Ctx) error {
-//	var u model.User
-//
-//	var usersSent model.SendUsers
-//	err := c.BodyParser(&usersSent)
-//	if err != nil {
-//		log.Println(err)
-//		return err
-//	}
-//
-//	db, err := model.Init()
-//	if err != nil {
-//		log.Println(err)
-//		return err
-//	}
-//	defer model.Close(db)
-//
-//	if !secrets.TokenCheck(C.SALT, usersSent.Users[0].Name, usersSent.Token) {
-//		c.Status(400)
-//		return c.JSON(fiber.Map{"message": "Invalid Request"})
-//	}
-//
-//	for _, user := range usersSent.Users {
-//		res := db.First(&u, "name = ?", user.Name)
-//		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-//			log.Println(res.Error)
-//			db.Create(&user)
-//		}
-//		if u.Nickname != user.Nickname {
-//			u.Nickname = user.Nickname
-//			db.Save(&u)
-//		}
-//	}
-//
-//	return c.JSON(fiber.Map{"message": "Success"})
-//}
-
-func InsertPostInfo(c *fiber.Ctx) error {
-	var postInfo model.PostInfo
-	err := c.BodyParser(&postInfo)
+func GetRank(c *fiber.Ctx) error {
+	var info model.RankInfo
+	err := c.BodyParser(&info)
 	if err != nil {
-		log.Println(err)
-		return err
+		return badParameter("Body", err)
 	}
 
-	rdb := model.InitRedis()
-	defer rdb.Close()
+	if !checkToken(c, strconv.FormatUint(uint64(info.Rank), 10), info.Token) {
+		return ErrBadToken
+	}
 
-	db, err := model.Init()
-	if err != nil {
-		log.Println(err)
-		return err
+	forum, ok := getForum(info.Forum)
+	if !ok {
+		return ErrBadForum
 	}
-	defer model.Close(db)
 
-	members, err := rdb.Get(ctx, "tieba_genshin_member_total").Uint64()
+	boundary, err := crawler.FindBoundary(ctx, forum, info.Level, info.Rank)
 	if err != nil {
-		log.Println(err)
-		return err
+		return upstreamTieba(err)
 	}
 
-	var users []model.User
-	queryRes := db.Find(&users, "member = ?", 1)
-	vip := queryRes.RowsAffected
+	return c.JSON(fiber.Map{
+		"rank":  boundary.Rank,
+		"level": boundary.Level,
+		"pages": boundary.Pages,
+	})
+}
 
-	if !secrets.TokenCheck(C.SALT, strconv.FormatUint(uint64(postInfo.Total), 10), postInfo.Token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+// GetDist Get level distribution of a day
+func GetDist(c *fiber.Ctx) error {
+	token := c.Query("token")
+	dateStr := c.Query("date")
+	if !checkToken(c, dateStr, token) {
+		return ErrBadToken
 	}
 
-	// Get current time
-	var now time.Time
-	if time.Now().Hour() < 8 {
-		now = time.Now().AddDate(0, 0, -1)
+	forum, ok := getForum(c.Query("forum"))
+	if !ok {
+		return ErrBadForum
+	}
+
+	day, err := time.Parse(C.DATEFMT, dateStr)
+	if err != nil {
+		return badParameter("Date", err)
+	}
+
+	currentDate := time.Now().Add(time.Hour * 8).Truncate(time.Hour * 24)
+	var oldDivider map[uint]uint
+	if day.Equal(currentDate) {
+		dividers, err := store.GetDividers(forum.Key)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		firstUser, err := store.TopUser(forum.Key)
+		if err == nil && (len(dividers) == 0 || dividers[0].Level < firstUser.Level) {
+			divider := model.Divider{
+				Forum: forum.Key,
+				Level: firstUser.Level,
+				Rank:  1,
+			}
+			if err = store.CreateDivider(&divider); err != nil {
+				log.Println(err)
+				return err
+			}
+			dividers = append([]model.Divider{divider}, dividers...)
+		}
+
+		lastDay := day.Add(time.Duration(-24) * time.Hour)
+		history, err := store.GetHistory(forum.Key, lastDay)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		err = json.Unmarshal([]byte(history.Distribution), &oldDivider)
+		if err != nil {
+			return err
+		}
+
+		var dist []model.DistRet
+		if err := cache.Get(ctx, model.DividerKey(forum.Key), &dist); err != nil {
+			var wg sync.WaitGroup
+			ch := make(chan model.DistRet)
+
+			for _, div := range dividers {
+				wg.Add(1)
+				go func(div model.Divider) {
+					defer wg.Done()
+					dr, e := getDist(forum, div.Level, div.Rank)
+					if e != nil {
+						// Keep the previous rank of the level
+						dr = model.DistRet{Level: div.Level, Rank: div.Rank, Delta: int(div.Rank)}
+					}
+					ch <- dr
+				}(div)
+			}
+			go func() {
+				wg.Wait()
+				close(ch)
+			}()
+
+			newDivider := make(map[uint]uint)
+			for dr := range ch {
+				newDivider[dr.Level] = dr.Rank
+				if e := store.UpdateDivider(forum.Key, dr.Level, dr.Rank); e != nil {
+					log.Println(e)
+				}
+			}
+			dist = getDelta(task.ConvertDivider(newDivider), oldDivider)
+		}
+
+		posts, members, err := crawler.GetTotal(ctx, forum)
+		if err != nil {
+			return upstreamTieba(err)
+		}
+
+		var membership uint64
+		if err = cache.Get(ctx, model.MemberTotalKey(forum.Key), &membership); err != nil {
+			log.Println(err)
+			return err
+		}
+
+		vip, err := store.CountVip(forum.Key)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		sort.Slice(dist, func(i, j int) bool {
+			return dist[i].Level > dist[j].Level
+		})
+
+		_ = cache.Set(ctx, model.DividerKey(forum.Key), dist, 10*time.Minute)
+
+		return c.JSON(fiber.Map{
+			"distribution": dist,
+			"total":        members,
+			"membership":   membership,
+			"vip":          vip,
+			"posts":        posts,
+			"signin":       0,
+		})
 	} else {
-		now = time.Now()
+		lastDay := day.Add(time.Duration(-24) * time.Hour)
+		var newDivider map[uint]uint
+
+		newHistory, err := store.GetHistory(forum.Key, day)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		oldHistory, err := store.GetHistory(forum.Key, lastDay)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		err = json.Unmarshal([]byte(newHistory.Distribution), &newDivider)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal([]byte(oldHistory.Distribution), &oldDivider)
+		if err != nil {
+			return err
+		}
+
+		result := getDelta(newDivider, oldDivider)
+
+		postInfo, err := store.GetPost(forum.Key, day)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Level > result[j].Level
+		})
+
+		return c.JSON(fiber.Map{
+			"distribution": result,
+			"total":        postInfo.Followers,
+			"membership":   postInfo.Members,
+			"vip":          postInfo.Vip,
+			"posts":        postInfo.Total,
+			"signin":       postInfo.Signin,
+		})
 	}
+}
 
-	post := model.Post{
-		Total:     postInfo.Total,
-		Date:      now,
-		Followers: postInfo.Followers,
-		Members:   uint(members),
-		Vip:       uint(vip),
-		Signin:    postInfo.Signin,
+func InsertPostInfo(c *fiber.Ctx) error {
+	var postInfo model.PostInfo
+	err := c.BodyParser(&postInfo)
+	if err != nil {
+		return badParameter("Body", err)
+	}
+
+	forum, ok := getForum(postInfo.Forum)
+	if !ok {
+		return ErrBadForum
 	}
-	db.Create(&post)
 
-	var distribute []model.Divider
-	db.Find(&distribute)
-	distMap := make(map[uint]uint)
-	for _, v := range distribute {
-		distMap[v.Level] = v.Rank
+	if !checkToken(c, strconv.FormatUint(uint64(postInfo.Total), 10), postInfo.Token) {
+		return ErrBadToken
 	}
-	distByte, err := json.Marshal(convertDivider(distMap))
+
+	post, err := task.SavePost(ctx, forum, model.Post{
+		Total:     postInfo.Total,
+		Date:      task.SnapshotDate(time.Now()),
+		Followers: postInfo.Followers,
+		Signin:    postInfo.Signin,
+	})
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	db.Create(&model.History{
-		Date:         now,
-		Distribution: string(distByte),
-	})
 
 	return c.JSON(fiber.Map{"data": post})
 }
 
+// GetSchedule Get last and next run of scheduled jobs
+func GetSchedule(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"jobs": task.Status()})
+}
+
 func GetIncome(c *fiber.Ctx) error {
 	token := c.Query("token")
 	startDate := c.Query("start")
 	endDate := c.Query("end")
-	upIncome := make([]model.UpIncome, 0)
 	var wg sync.WaitGroup
 
-	if !secrets.TokenCheck(C.SALT, startDate+endDate, token) {
-		c.Status(400)
-		return c.JSON(fiber.Map{"message": "Invalid Request"})
+	if !checkToken(c, startDate+endDate, token) {
+		return ErrBadToken
 	}
 
-	startTime, _ := time.Parse(C.SHORT_DATE, startDate)
-	endTime, _ := time.Parse(C.SHORT_DATE, endDate)
-	incomeData, err := crawler.GetIncomeData(startTime, endTime)
+	startTime, err := time.Parse(C.SHORT_DATE, startDate)
 	if err != nil {
-		c.Status(500)
-		return err
+		return badParameter("Start Date", err)
 	}
-
-	db, err := model.Init()
+	endTime, err := time.Parse(C.SHORT_DATE, endDate)
 	if err != nil {
-		log.Println(err)
-		return err
+		return badParameter("End Date", err)
+	}
+	incomeData, err := crawler.GetIncomeData(ctx, startTime, endTime)
+	if err != nil {
+		return upstreamIncome(err)
 	}
-	defer model.Close(db)
 
 	// Get data of income in a period of time
-	incomes, average := parseIncomeData(incomeData)
+	incomes, average, err := parseIncomeData(incomeData)
+	if err != nil {
+		return upstreamIncome(err)
+	}
 
 	// Refresh data of UpIncome
-	db.Where("date < ?", time.Now().Format(C.DATEFMT)).Find(&upIncome)
+	upIncome, err := store.GetIncomesBefore(time.Now())
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	for i := range upIncome {
+		if upIncome[i].Final {
+			continue
+		}
 		if upIncome[i].Date.Add(30*24*time.Hour).Unix() > time.Now().Unix() || upIncome[i].Income == 0 {
 			wg.Add(1)
 			refreshData(&upIncome[i], &wg)
 		}
 	}
 	wg.Wait()
-	db.Save(&upIncome)
+	if err = store.SaveIncomes(upIncome); err != nil {
+		log.Println(err)
+	}
 
 	sort.Slice(upIncome, func(i, j int) bool {
 		return upIncome[i].Date.Unix() > upIncome[j].Date.Unix()
 	})
 
-	monthIncome := getMonthIncome()
+	monthIncome, err := getMonthIncome()
+	if err != nil {
+		return upstreamIncome(err)
+	}
 
 	return c.JSON(fiber.Map{
 		"average": average,
@@ -742,26 +642,22 @@ func GetWallpaper(c *fiber.Ctx) error {
 	requestType := c.Query("type")
 	var ret model.WallpaperRet
 
-	res, err := http.Get("https://www.bing.com/HPImageArchive.aspx?format=js&idx=0&n=1")
+	res, err := crawler.Get(ctx, "https://www.bing.com/HPImageArchive.aspx?format=js&idx=0&n=1")
 	if err != nil {
-		c.Status(500)
-		return c.JSON(fiber.Map{"message": err.Error()})
+		return upstream("bing", err)
 	}
 
 	defer res.Body.Close()
 	if res.StatusCode != 200 {
-		log.Printf("Status code err: %d %s", res.StatusCode, res.Status)
-		return c.JSON(fiber.Map{
-			"message": fmt.Sprintf("Status code err: %d %s", res.StatusCode, res.Status),
-		})
+		return upstream("bing", fmt.Errorf("status code err: %d %s", res.StatusCode, res.Status))
 	}
 
 	err = json.NewDecoder(res.Body).Decode(&ret)
-	if err != nil && len(ret.Images) < 1 {
-		c.Status(500)
-		return c.JSON(fiber.Map{
-			"message": err.Error(),
-		})
+	if len(ret.Images) < 1 {
+		if err == nil {
+			err = errors.New("no image")
+		}
+		return upstream("bing", err)
 	}
 
 	wallpaperUrl := "https://www.bing.com" + ret.Images[0].Url
@@ -770,19 +666,13 @@ func GetWallpaper(c *fiber.Ctx) error {
 			"url": wallpaperUrl,
 		})
 	} else if requestType == "img" {
-		wallpaperRes, e := http.Get(wallpaperUrl)
+		wallpaperRes, e := crawler.Get(ctx, wallpaperUrl)
 		if e != nil {
-			c.Status(500)
-			return c.JSON(fiber.Map{
-				"message": "Failed to get image",
-			})
+			return upstream("bing", e)
 		}
 		defer wallpaperRes.Body.Close()
 		return c.SendStream(wallpaperRes.Body)
 	}
 
-	c.Status(400)
-	return c.JSON(fiber.Map{
-		"message": "Invalid Request",
-	})
+	return badParameter("Type", nil)
 }
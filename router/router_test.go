@@ -0,0 +1,77 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestApp Set up handlers with an in-memory store and cache, requests are signed by a key with every scope
+func newTestApp(t *testing.T) (*fiber.App, model.Store) {
+	t.Helper()
+	s, err := model.OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	Setup(config.NewLive(config.Config{}), s, model.NewMemoryCache(100))
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals(keyLocal, model.APIKey{KeyId: "test", Name: "test", Scopes: "admin"})
+		return c.Next()
+	})
+	return app, s
+}
+
+func TestGetUserHistory(t *testing.T) {
+	app, s := newTestApp(t)
+	app.Get("/user/:name/history", GetUserHistory)
+
+	day := time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+	users := []model.TiebaUser{{Rank: 2, Name: "alice", Link: "/alice", Level: 12, Exp: 100}}
+	if err := s.SaveUsers("genshin", users, day); err != nil {
+		t.Fatal(err)
+	}
+	users[0].Rank, users[0].Exp = 1, 150
+	if err := s.SaveUsers("genshin", users, day.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := app.Test(httptest.NewRequest("GET", "/user/alice/history", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("got status %d, want 200", res.StatusCode)
+	}
+	var body struct {
+		Daily []model.UserDaily `json:"daily"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Daily) != 2 || body.Daily[1].ExpGain != 50 || body.Daily[1].RankChange != 1 {
+		t.Errorf("got daily %+v", body.Daily)
+	}
+
+	res, err = app.Test(httptest.NewRequest("GET", "/user/bob/history", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr APIError
+	if err = json.NewDecoder(res.Body).Decode(&apiErr); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != fiber.StatusNotFound || apiErr.Code != CodeNotFound {
+		t.Errorf("got status %d code %v for unknown user, want 404 %v", res.StatusCode, apiErr.Code, CodeNotFound)
+	}
+}
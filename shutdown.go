@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/router"
+	"github.com/DRJ31/tiebarankgo/task"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	shutdownTimeout = 30 * time.Second // Time in-flight requests are given to finish
+	cancelTimeout   = 5 * time.Second  // Time requests are given to return after their crawls are canceled
+)
+
+// shutdown Stop accepting requests and wait for in-flight ones until the deadline, then cancel their crawls.
+// Roster crawls and jobs are canceled at once, roster crawls resume from their checkpoints.
+func shutdown(app *fiber.App) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Shutdown()
+	}()
+
+	if err := task.Shutdown(ctx); err != nil {
+		log.Printf("Jobs still running after shutdown deadline: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Println(err)
+		}
+	case <-ctx.Done():
+		log.Println("Requests still running after shutdown deadline, canceling their crawls")
+		router.CancelCrawls()
+		select {
+		case <-done:
+		case <-time.After(cancelTimeout):
+			log.Println("Requests did not return after their crawls were canceled")
+		}
+	}
+
+	// Page refreshes in background may still be running
+	router.CancelCrawls()
+}
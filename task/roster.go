@@ -0,0 +1,271 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/crawler"
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/DRJ31/tiebarankgo/secrets"
+)
+
+// RosterBatchPages Number of pages saved together in a roster crawl
+const RosterBatchPages = 10
+
+var (
+	ErrRosterRunning    = errors.New("roster crawl is already running")
+	ErrRosterNotRunning = errors.New("roster crawl is not running")
+)
+
+// RosterStatus Progress of a full roster crawl of a forum, saved in redis as checkpoint
+type RosterStatus struct {
+	Forum      string     `json:"forum"`
+	Running    bool       `json:"running"`
+	Page       uint       `json:"page"` // Last page saved
+	TotalPages uint       `json:"total_pages"`
+	Users      uint       `json:"users"` // Users saved since the crawl started
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Error      string     `json:"error"`
+	Progress   float64    `json:"progress"`
+	ETA        *time.Time `json:"eta"`
+
+	resumedPage uint
+	resumedAt   time.Time
+}
+
+var roster = struct {
+	sync.Mutex
+	cancels  map[string]context.CancelFunc
+	statuses map[string]*RosterStatus
+}{
+	cancels:  make(map[string]context.CancelFunc),
+	statuses: make(map[string]*RosterStatus),
+}
+
+func loadRosterStatus(forum config.Forum) RosterStatus {
+	status := RosterStatus{Forum: forum.Key}
+
+	err := cache.Get(context.Background(), model.RosterKey(forum.Key), &status)
+	if err != nil && !errors.Is(err, model.ErrCacheMiss) {
+		log.Println(err)
+	}
+	// Crawls in progress are kept in roster.statuses, one saved as running was interrupted by a crash
+	status.Running = false
+	status.ETA = nil
+	return status
+}
+
+func saveRosterStatus(status RosterStatus) {
+	if err := cache.Set(context.Background(), model.RosterKey(status.Forum), status, 0); err != nil {
+		log.Println(err)
+	}
+}
+
+// update Renew progress and ETA of the crawl, must be called with roster locked
+func (status *RosterStatus) update() {
+	status.UpdatedAt = time.Now()
+	if status.TotalPages > 0 {
+		status.Progress = float64(status.Page) / float64(status.TotalPages)
+	}
+
+	status.ETA = nil
+	done := status.Page - status.resumedPage
+	if status.Running && done > 0 && status.TotalPages > status.Page {
+		perPage := time.Since(status.resumedAt) / time.Duration(done)
+		eta := time.Now().Add(perPage * time.Duration(status.TotalPages-status.Page))
+		status.ETA = &eta
+	}
+}
+
+// GetRosterStatus Get progress of the roster crawl of a forum
+func GetRosterStatus(forum config.Forum) RosterStatus {
+	roster.Lock()
+	defer roster.Unlock()
+
+	if status, ok := roster.statuses[forum.Key]; ok {
+		return *status
+	}
+	return loadRosterStatus(forum)
+}
+
+// StartRoster Start crawling every page of a forum, resuming from the checkpoint unless restart is set
+func StartRoster(forum config.Forum, restart bool) (RosterStatus, error) {
+	roster.Lock()
+	defer roster.Unlock()
+
+	if _, ok := roster.cancels[forum.Key]; ok {
+		return *roster.statuses[forum.Key], ErrRosterRunning
+	}
+
+	status := loadRosterStatus(forum)
+	if restart || status.FinishedAt != nil || status.TotalPages == 0 {
+		status = RosterStatus{Forum: forum.Key, StartedAt: time.Now()}
+	}
+	status.Running = true
+	status.Error = ""
+	status.resumedPage = status.Page
+	status.resumedAt = time.Now()
+	status.update()
+
+	ctx, cancel := context.WithCancel(baseCtx)
+	roster.cancels[forum.Key] = cancel
+	roster.statuses[forum.Key] = &status
+	saveRosterStatus(status)
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		runRoster(ctx, forum, &status)
+	}()
+	return status, nil
+}
+
+// StopRoster Stop the roster crawl of a forum, it can be resumed from the last saved page
+func StopRoster(forum config.Forum) (RosterStatus, error) {
+	roster.Lock()
+	defer roster.Unlock()
+
+	cancel, ok := roster.cancels[forum.Key]
+	if !ok {
+		return loadRosterStatus(forum), ErrRosterNotRunning
+	}
+	cancel()
+	return *roster.statuses[forum.Key], nil
+}
+
+func runRoster(ctx context.Context, forum config.Forum, status *RosterStatus) {
+	err := crawlRoster(ctx, forum, status)
+
+	roster.Lock()
+	status.Running = false
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Roster crawl of %v stopped at page %d", forum.Key, status.Page)
+	} else if err != nil {
+		log.Printf("Roster crawl of %v failed: %v", forum.Key, err)
+		status.Error = err.Error()
+	} else {
+		finishedAt := time.Now()
+		status.FinishedAt = &finishedAt
+		log.Printf("Roster crawl of %v finished with %d users", forum.Key, status.Users)
+	}
+	status.update()
+	saveRosterStatus(*status)
+
+	delete(roster.cancels, forum.Key)
+	delete(roster.statuses, forum.Key)
+	finished := *status
+	roster.Unlock()
+
+	// Notified without roster locked, so a slow webhook does not block status requests
+	if err == nil {
+		notifyRoster(finished)
+	}
+}
+
+func crawlRoster(ctx context.Context, forum config.Forum, status *RosterStatus) error {
+	batch := make([]model.TiebaUser, 0, RosterBatchPages*20)
+	roster.Lock()
+	page := status.Page
+	roster.Unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		page++
+		users, err := crawler.GetUsers(ctx, forum, page, store)
+		if err != nil {
+			return err
+		}
+
+		// Total members are saved by crawler.GetUsers
+		roster.Lock()
+		var total uint
+		if err := cache.Get(ctx, model.MemberTotalKey(forum.Key), &total); err == nil && total > 0 {
+			status.TotalPages = (total + 19) / 20
+		}
+		totalPages := status.TotalPages
+		roster.Unlock()
+
+		batch = append(batch, users...)
+		empty := len(users) == 0
+		last := empty || (totalPages > 0 && page >= totalPages)
+		if last || page%RosterBatchPages == 0 {
+			if err = store.SaveUsers(forum.Key, batch, time.Now()); err != nil {
+				return err
+			}
+
+			roster.Lock()
+			status.Users += uint(len(batch))
+			status.Page = page
+			if empty {
+				status.Page = page - 1
+			}
+			if last {
+				status.TotalPages = status.Page
+			}
+			status.update()
+			saveRosterStatus(*status)
+			log.Printf("Roster crawl of %v saved page %d/%d", forum.Key, status.Page, status.TotalPages)
+			roster.Unlock()
+
+			batch = batch[:0]
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// notifyClient Client of WeCom webhook
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifyRoster Send result of a finished roster crawl to WeCom webhook
+func notifyRoster(status RosterStatus) {
+	key := conf.Get().NotifyKey
+	if key == "" {
+		return
+	}
+
+	content := fmt.Sprintf("### 用户信息\n贴吧: %v\n总人数: <font color=\"comment\">%d</font>", status.Forum, status.Users)
+	loc := fmt.Sprintf("https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=%v", key)
+
+	var msg secrets.WxMsgMarkdown
+	msg.Markdown.Content = content
+	msg.Msgtype = "markdown"
+
+	jsonStr, err := json.Marshal(msg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	res, err := notifyClient.Post(loc, "application/json", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer res.Body.Close()
+}
+
+// rosterAll Start roster crawls of all configured forums
+func rosterAll(ctx context.Context, at time.Time) error {
+	var last error
+	for _, forum := range conf.Get().GetForums() {
+		if _, err := StartRoster(forum, false); err != nil && !errors.Is(err, ErrRosterRunning) {
+			log.Printf("Roster crawl of %v failed to start: %v", forum.Key, err)
+			last = err
+		}
+	}
+	return last
+}
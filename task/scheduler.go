@@ -0,0 +1,283 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/model"
+	"github.com/robfig/cron/v3"
+)
+
+// DefaultSchedules Schedules of built-in jobs used when they are not configured
+var DefaultSchedules = map[string]string{
+	"snapshot": "0 0 * * *",
+	"roster":   "",
+}
+
+// JobStatus Last and next run of a scheduled job
+type JobStatus struct {
+	Name      string     `json:"name"`
+	Schedule  string     `json:"schedule"`
+	Running   bool       `json:"running"`
+	LastRun   *time.Time `json:"last_run"`
+	LastError string     `json:"last_error"`
+	NextRun   *time.Time `json:"next_run"`
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	run      func(ctx context.Context, at time.Time) error
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+	lastErr error
+}
+
+// Scheduler Run jobs periodically inside the server
+type Scheduler struct {
+	cron *cron.Cron
+	jobs map[string]*job
+}
+
+var (
+	conf             *config.Live
+	store            model.Store
+	cache            model.Cache
+	defaultScheduler *Scheduler
+	// baseCtx Parent of contexts of jobs and roster crawls, canceled on shutdown
+	baseCtx, cancelBase = context.WithCancel(context.Background())
+	running             sync.WaitGroup // Jobs and roster crawls in progress
+	builtinJobs         = map[string]func(ctx context.Context, at time.Time) error{
+		"snapshot": snapshotAll,
+		"roster":   rosterAll,
+	}
+)
+
+// NewScheduler Create scheduler of built-in jobs with schedules in cf
+func NewScheduler(cf config.Config) (*Scheduler, error) {
+	s := &Scheduler{
+		cron: cron.New(),
+		jobs: make(map[string]*job),
+	}
+
+	for name, run := range builtinJobs {
+		spec, ok := cf.Schedules[name]
+		if !ok {
+			spec = DefaultSchedules[name]
+		}
+		if spec == "" {
+			// Job disabled
+			continue
+		}
+		if err := s.add(name, spec, run); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Scheduler) add(name, spec string, run func(ctx context.Context, at time.Time) error) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return err
+	}
+
+	j := &job{name: name, spec: spec, schedule: schedule, run: run}
+	s.cron.Schedule(schedule, cron.FuncJob(func() {
+		// Shutdown waits for cron jobs to return before waiting for running
+		running.Add(1)
+		s.execute(j, time.Now())
+	}))
+	s.jobs[name] = j
+	return nil
+}
+
+// execute Run job once, runs overlapping a running one are skipped.
+// Callers add the run to running before starting it, execute marks it done.
+func (s *Scheduler) execute(j *job, at time.Time) {
+	defer running.Done()
+
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		log.Printf("Job %v is still running, skipped", j.name)
+		return
+	}
+	j.running = true
+	j.mu.Unlock()
+
+	start := time.Now()
+	err := j.run(baseCtx, at)
+	if err != nil {
+		log.Printf("Job %v failed: %v", j.name, err)
+	} else {
+		log.Printf("Job %v finished in %v", j.name, time.Since(start))
+	}
+
+	j.mu.Lock()
+	j.running = false
+	j.lastRun = start
+	j.lastErr = err
+	j.mu.Unlock()
+
+	// Failed runs are not recorded, so they are caught up after a restart
+	if err == nil {
+		if err = store.SaveJobRun(j.name, at); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// previousRun Get the latest time of schedule not after now, zero if there is none in the past year
+func previousRun(schedule cron.Schedule, now time.Time) time.Time {
+	// Go back until a scheduled time is found, then forward to the latest one
+	start := now
+	for back := time.Hour; back <= 366*24*time.Hour; back *= 2 {
+		start = now.Add(-back)
+		if !schedule.Next(start).After(now) {
+			break
+		}
+	}
+
+	prev := time.Time{}
+	for next := schedule.Next(start); !next.After(now); next = schedule.Next(next) {
+		prev = next
+	}
+	return prev
+}
+
+// catchUp Run jobs whose last scheduled run was missed or failed while the server was down.
+// Jobs that never succeeded catch up only their previous scheduled run.
+func (s *Scheduler) catchUp() {
+	now := time.Now()
+	for _, j := range s.jobs {
+		last, err := store.GetJobRun(j.name)
+		if errors.Is(err, model.ErrNotFound) {
+			if prev := previousRun(j.schedule, now); !prev.IsZero() {
+				log.Printf("Job %v has no previous run, catching up run at %v", j.name, prev)
+				running.Add(1)
+				go s.execute(j, prev)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Job %v not caught up: %v", j.name, err)
+			continue
+		}
+
+		// Find the latest scheduled time after the last run
+		missed := time.Time{}
+		for next := j.schedule.Next(last); !next.After(now); next = j.schedule.Next(next) {
+			missed = next
+		}
+		if !missed.IsZero() {
+			log.Printf("Job %v missed run at %v, catching up", j.name, missed)
+			running.Add(1)
+			go s.execute(j, missed)
+		}
+	}
+}
+
+// Start Catch up missed runs and start the scheduler
+func (s *Scheduler) Start() {
+	s.catchUp()
+	s.cron.Start()
+}
+
+// Stop Stop scheduling new runs, running jobs are not interrupted.
+// The context returned is done when jobs started by cron have returned.
+func (s *Scheduler) Stop() context.Context {
+	return s.cron.Stop()
+}
+
+// Status Get status of all jobs
+func (s *Scheduler) Status() []JobStatus {
+	result := make([]JobStatus, 0, len(s.jobs))
+	now := time.Now()
+
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		status := JobStatus{
+			Name:     j.name,
+			Schedule: j.spec,
+			Running:  j.running,
+		}
+		if !j.lastRun.IsZero() {
+			lastRun := j.lastRun
+			status.LastRun = &lastRun
+		}
+		if j.lastErr != nil {
+			status.LastError = j.lastErr.Error()
+		}
+		j.mu.Unlock()
+
+		next := j.schedule.Next(now)
+		status.NextRun = &next
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Setup Set config, store and cache used by jobs
+func Setup(cf *config.Live, s model.Store, c model.Cache) {
+	conf = cf
+	store = s
+	cache = c
+}
+
+// Start Create and start the default scheduler with schedules in config
+func Start() error {
+	s, err := NewScheduler(conf.Get())
+	if err != nil {
+		return err
+	}
+	defaultScheduler = s
+	s.Start()
+	return nil
+}
+
+// Shutdown Stop the default scheduler, cancel running jobs and roster crawls and wait for them until ctx is done
+func Shutdown(ctx context.Context) error {
+	cancelBase()
+	if defaultScheduler != nil {
+		// Cron jobs add their runs before returning, so nothing is added to running after Wait starts
+		select {
+		case <-defaultScheduler.Stop().Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Status Get status of jobs in the default scheduler
+func Status() []JobStatus {
+	if defaultScheduler == nil {
+		return []JobStatus{}
+	}
+	return defaultScheduler.Status()
+}
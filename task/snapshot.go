@@ -0,0 +1,132 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/DRJ31/tiebarankgo/config"
+	"github.com/DRJ31/tiebarankgo/crawler"
+	"github.com/DRJ31/tiebarankgo/model"
+	C "github.com/DRJ31/tiebarankgo/secrets/constants"
+)
+
+// SnapshotDate Get the day a snapshot taken at t belongs to, snapshots taken before 8am count as yesterday
+func SnapshotDate(t time.Time) time.Time {
+	if t.Hour() < 8 {
+		return t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// ConvertDivider Convert cumulative ranks of dividers into number of users in each level
+func ConvertDivider(old map[uint]uint) map[uint]uint {
+	var tmp []model.DistInfo
+	mp := make(map[uint]uint)
+
+	for k, v := range old {
+		tmp = append(tmp, model.DistInfo{
+			Level: k,
+			Rank:  v,
+		})
+	}
+
+	sort.Slice(tmp, func(i, j int) bool {
+		return tmp[i].Level > tmp[j].Level
+	})
+
+	var sum uint = 0
+	for i := range tmp {
+		if i > 0 {
+			tmp[i].Rank -= sum
+		}
+		sum += tmp[i].Rank
+	}
+
+	for _, elem := range tmp {
+		mp[elem.Level] = elem.Rank
+	}
+	return mp
+}
+
+// SavePost Save post info of a forum together with its current level distribution,
+// model.ErrDuplicate is returned when the forum already has a post on the day
+func SavePost(ctx context.Context, forum config.Forum, post model.Post) (model.Post, error) {
+	members, err := crawler.MemberTotal(ctx, forum)
+	if err != nil {
+		log.Println(err)
+		return model.Post{}, err
+	}
+
+	vip, err := store.CountVip(forum.Key)
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	distribute, err := store.GetDividers(forum.Key)
+	if err != nil {
+		return model.Post{}, err
+	}
+	distMap := make(map[uint]uint)
+	for _, v := range distribute {
+		distMap[v.Level] = v.Rank
+	}
+	distByte, err := json.Marshal(ConvertDivider(distMap))
+	if err != nil {
+		log.Println(err)
+		return model.Post{}, err
+	}
+
+	post.Forum = forum.Key
+	post.Members = members
+	post.Vip = uint(vip)
+	err = store.CreatePost(&post, &model.History{
+		Forum:        forum.Key,
+		Date:         post.Date,
+		Distribution: string(distByte),
+	})
+	if err != nil {
+		return model.Post{}, err
+	}
+	return post, nil
+}
+
+// TakeSnapshot Take the daily post and distribution snapshot of a forum, days already saved are skipped
+func TakeSnapshot(ctx context.Context, forum config.Forum, at time.Time) error {
+	day := SnapshotDate(at)
+
+	_, err := store.GetPost(forum.Key, day)
+	if !errors.Is(err, model.ErrNotFound) {
+		if err == nil {
+			log.Printf("Snapshot of %v on %v already exists", forum.Key, day.Format(C.DATEFMT))
+		}
+		return err
+	}
+
+	posts, members, err := crawler.GetTotal(ctx, forum)
+	if err != nil {
+		return err
+	}
+
+	_, err = SavePost(ctx, forum, model.Post{
+		Date:      day,
+		Total:     posts,
+		Followers: members,
+	})
+	return err
+}
+
+// snapshotAll Take snapshots of all configured forums
+func snapshotAll(ctx context.Context, at time.Time) error {
+	var last error
+	for _, forum := range conf.Get().GetForums() {
+		if err := TakeSnapshot(ctx, forum, at); err != nil {
+			log.Printf("Snapshot of %v failed: %v", forum.Key, err)
+			last = err
+		}
+	}
+	return last
+}